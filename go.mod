@@ -20,5 +20,7 @@ require (
 	go.elastic.co/apm/module/apmot v1.9.0
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	golang.org/x/sys v0.0.0-20201026173827-119d4633e4d1 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 	howett.net/plist v0.0.0-20201026045517-117a925f2150 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
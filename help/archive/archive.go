@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gdcorp-infosec/threat-util/help/filetype"
+)
+
+const (
+	TypeZip      = "zip"
+	TypeTar      = "tar"
+	TypeGzip     = "gzip"
+	TypeBzip2    = "bzip2"
+	TypeSevenZip = "7z"
+	TypeRar      = "rar"
+)
+
+var (
+	ErrUnsupported      = errors.New("archive format cannot be extracted")
+	ErrEncrypted        = errors.New("entry is encrypted")
+	ErrCompressionRatio = errors.New("compression ratio exceeds limit")
+	ErrTotalSizeLimit   = errors.New("total expanded size limit reached")
+	ErrFileCountLimit   = errors.New("file count limit reached")
+)
+
+// Limits guard against archives that expand to more data than can be handled, such as
+// zip bombs. Zero values disable a limit.
+type Limits struct {
+	// Maximum nesting of archives within archives, used by callers that recurse
+	MaxDepth int
+	// Maximum number of bytes extracted across all archives
+	MaxTotalBytes int64
+	// Maximum number of entries extracted across all archives
+	MaxFiles int
+	// Maximum ratio of expanded to compressed size for an entry
+	MaxCompressionRatio float64
+}
+
+var DefaultLimits = Limits{
+	MaxDepth:            5,
+	MaxTotalBytes:       256 * 1024 * 1024,
+	MaxFiles:            1000,
+	MaxCompressionRatio: 100,
+}
+
+type Entry struct {
+	Path      string
+	Data      []byte
+	Encrypted bool
+	// Set when the entry was found but not extracted
+	Err error
+}
+
+// Extractor extracts archives while keeping count of the bytes and files extracted, so
+// that its limits apply to a whole tree of nested archives.
+type Extractor struct {
+	Limits Limits
+
+	TotalBytes int64
+	Files      int
+}
+
+func NewExtractor(limits Limits) *Extractor {
+	return &Extractor{Limits: limits}
+}
+
+// Type returns the archive type of data, or an empty string for other data
+func Type(data []byte) string {
+	fileTypes := filetype.Get(data)
+
+	switch {
+	case fileTypes.Matches(filetype.Zip):
+		return TypeZip
+	case fileTypes.Matches(filetype.Gzip):
+		return TypeGzip
+	case fileTypes.Matches(filetype.Bzip2):
+		return TypeBzip2
+	case fileTypes.Matches(filetype.Tar):
+		return TypeTar
+	case fileTypes.Matches(filetype.SevenZip):
+		return TypeSevenZip
+	case fileTypes.Matches(filetype.Rar):
+		return TypeRar
+	}
+
+	return ""
+}
+
+// Extract returns the entries of an archive. Entries that are encrypted or break a limit
+// are returned with Err set. An error is returned when the archive cannot be read or when
+// a limit stops extraction, along with the entries extracted before that.
+func (e *Extractor) Extract(data []byte) ([]Entry, error) {
+	switch Type(data) {
+	case TypeZip:
+		return e.extractZip(data)
+	case TypeTar:
+		return e.extractTar(data)
+	case TypeGzip:
+		return e.extractGzip(data)
+	case TypeBzip2:
+		return e.extractStream("data", bzip2.NewReader(bytes.NewReader(data)), int64(len(data)))
+	}
+
+	return nil, ErrUnsupported
+}
+
+// Reads r, enforcing the total size limit and, when compressedSize is positive, the
+// compression ratio limit
+func (e *Extractor) read(r io.Reader, compressedSize int64) ([]byte, error) {
+	if e.Limits.MaxFiles > 0 && e.Files >= e.Limits.MaxFiles {
+		return nil, ErrFileCountLimit
+	}
+
+	limit := int64(-1)
+	limitErr := error(nil)
+	if e.Limits.MaxTotalBytes > 0 {
+		limit = e.Limits.MaxTotalBytes - e.TotalBytes
+		limitErr = ErrTotalSizeLimit
+	}
+	if e.Limits.MaxCompressionRatio > 0 && compressedSize > 0 {
+		ratioLimit := int64(float64(compressedSize) * e.Limits.MaxCompressionRatio)
+		if limit < 0 || ratioLimit < limit {
+			limit = ratioLimit
+			limitErr = ErrCompressionRatio
+		}
+	}
+
+	if limit >= 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+
+	if limit >= 0 && int64(buf.Len()) > limit {
+		return nil, limitErr
+	}
+
+	e.TotalBytes += int64(buf.Len())
+	e.Files++
+
+	return buf.Bytes(), nil
+}
+
+// Limit errors that stop extraction of the remaining entries
+func isStopError(err error) bool {
+	return err == ErrTotalSizeLimit || err == ErrFileCountLimit
+}
+
+func (e *Extractor) extractZip(data []byte) ([]Entry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		entry := Entry{Path: f.Name}
+		if f.Flags&0x1 != 0 {
+			entry.Encrypted = true
+			entry.Err = ErrEncrypted
+			entries = append(entries, entry)
+			continue
+		}
+
+		if e.Limits.MaxCompressionRatio > 0 && f.CompressedSize64 > 0 &&
+			float64(f.UncompressedSize64)/float64(f.CompressedSize64) > e.Limits.MaxCompressionRatio {
+			entry.Err = ErrCompressionRatio
+			entries = append(entries, entry)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			entry.Err = err
+			entries = append(entries, entry)
+			continue
+		}
+
+		compressedSize := int64(f.CompressedSize64)
+		if f.Method == zip.Store {
+			compressedSize = 0
+		}
+		entry.Data, entry.Err = e.read(rc, compressedSize)
+		rc.Close()
+
+		if isStopError(entry.Err) {
+			return entries, entry.Err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (e *Extractor) extractTar(data []byte) ([]Entry, error) {
+	tr := tar.NewReader(bytes.NewReader(data))
+
+	var entries []Entry
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		entry := Entry{Path: header.Name}
+		entry.Data, entry.Err = e.read(tr, 0)
+		if isStopError(entry.Err) {
+			return entries, entry.Err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (e *Extractor) extractGzip(data []byte) ([]Entry, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	name := path.Base(gr.Name)
+	if gr.Name == "" {
+		name = "data"
+	}
+
+	return e.extractStream(name, gr, int64(len(data)))
+}
+
+func (e *Extractor) extractStream(name string, r io.Reader, compressedSize int64) ([]Entry, error) {
+	entry := Entry{Path: strings.TrimSpace(name)}
+	entry.Data, entry.Err = e.read(r, compressedSize)
+	if isStopError(entry.Err) {
+		return nil, entry.Err
+	}
+
+	return []Entry{entry}, nil
+}
@@ -0,0 +1,43 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/archive"
+)
+
+func TestArchive(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	tw.Write([]byte("abc"))
+	tw.Close()
+
+	if archive.Type(buf.Bytes()) != archive.TypeTar {
+		t.Fatalf("tar not detected")
+	}
+
+	entries, err := archive.NewExtractor(archive.DefaultLimits).Extract(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 || entries[0].Path != "dir/a.txt" || string(entries[0].Data) != "abc" {
+		t.Fatalf("bad entries: %+v", entries)
+	}
+
+	// Total size limit
+	_, err = archive.NewExtractor(archive.Limits{MaxTotalBytes: 2}).Extract(buf.Bytes())
+	if err != archive.ErrTotalSizeLimit {
+		t.Fatalf("expected total size limit, got %v", err)
+	}
+
+	sevenZip, _ := hex.DecodeString("377ABCAF271C0004")
+	if archive.Type(sevenZip) != archive.TypeSevenZip {
+		t.Fatalf("7z not detected")
+	} else if _, err := archive.NewExtractor(archive.DefaultLimits).Extract(sevenZip); err != archive.ErrUnsupported {
+		t.Fatalf("expected unsupported error")
+	}
+}
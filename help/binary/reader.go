@@ -0,0 +1,263 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrOutOfBounds = errors.New("read out of bounds")
+
+// BoundsError is kept by a Reader when a read, seek or window does not fit in its data
+type BoundsError struct {
+	// Position in the reader's window, and the number of bytes wanted there
+	Offset int64
+	Size   int64
+	// Length of the window
+	Length int64
+}
+
+func (e *BoundsError) Error() string {
+	return fmt.Sprintf("%v: %v bytes at offset %v of %v", ErrOutOfBounds, e.Size, e.Offset, e.Length)
+}
+
+func (e *BoundsError) Unwrap() error {
+	return ErrOutOfBounds
+}
+
+// Reader reads values one after another from a window of a byte slice or an io.ReaderAt.
+// The first error is kept and makes every later read return zero values, so a header
+// can be read in a straight line with one check of Err at the end.
+type Reader struct {
+	// Used by reads without an explicit byte order, and may be changed between reads
+	Endianness Endianness
+
+	data []byte
+	ra   io.ReaderAt
+	// Window of the underlying data the reader covers
+	base   int64
+	length int64
+
+	pos int64
+	err error
+	buf [8]byte
+}
+
+func NewReader(data []byte, endianness Endianness) *Reader {
+	return &Reader{Endianness: endianness, data: data, length: int64(len(data))}
+}
+
+// NewReaderAt reads the first size bytes of ra
+func NewReaderAt(ra io.ReaderAt, size int64, endianness Endianness) *Reader {
+	return &Reader{Endianness: endianness, ra: ra, length: size}
+}
+
+// Err returns the first error, nil when every read so far fit
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Pos returns the position in the window
+func (r *Reader) Pos() int64 {
+	return r.pos
+}
+
+// Len returns the length of the window
+func (r *Reader) Len() int64 {
+	return r.length
+}
+
+// Remaining returns the number of bytes after the position
+func (r *Reader) Remaining() int64 {
+	return r.length - r.pos
+}
+
+func (r *Reader) fail(offset int64, size int64) {
+	if r.err == nil {
+		r.err = &BoundsError{Offset: offset, Size: size, Length: r.length}
+	}
+}
+
+// Seek moves the position as io.Seeker does. Positions outside the window are errors.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.err != nil {
+		return r.pos, r.err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.length
+	}
+
+	if offset < 0 || offset > r.length {
+		r.fail(offset, 0)
+		return r.pos, r.err
+	}
+
+	r.pos = offset
+	return r.pos, nil
+}
+
+// Skip moves the position n bytes forward
+func (r *Reader) Skip(n int64) {
+	if n < 0 || n > r.Remaining() {
+		r.fail(r.pos, n)
+		return
+	}
+
+	if r.err == nil {
+		r.pos += n
+	}
+}
+
+// Align moves the position forward to a multiple of n from the start of the window
+func (r *Reader) Align(n int64) {
+	if n <= 1 || r.pos%n == 0 {
+		return
+	}
+
+	r.Skip(n - r.pos%n)
+}
+
+// Window returns a reader over length bytes at offset in this reader's window, without
+// moving the position. A window that does not fit returns a reader holding the error.
+func (r *Reader) Window(offset int64, length int64) *Reader {
+	w := &Reader{Endianness: r.Endianness, data: r.data, ra: r.ra, base: r.base + offset, length: length}
+
+	switch {
+	case r.err != nil:
+		w.err = r.err
+	case offset < 0 || length < 0 || offset > r.length || length > r.length-offset:
+		w.err = &BoundsError{Offset: offset, Size: length, Length: r.length}
+	}
+
+	return w
+}
+
+// Sub returns a reader over the next length bytes and moves the position past them
+func (r *Reader) Sub(length int64) *Reader {
+	w := r.Window(r.pos, length)
+	r.Skip(length)
+	return w
+}
+
+// Returns the next n bytes and moves past them. For slices the result shares memory with
+// the data, otherwise it is only valid until the next read.
+func (r *Reader) read(n int64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > r.Remaining() {
+		r.fail(r.pos, n)
+		return nil
+	}
+
+	var p []byte
+	if r.data != nil || r.ra == nil {
+		p = r.data[r.base+r.pos : r.base+r.pos+n]
+	} else {
+		if n <= int64(len(r.buf)) {
+			p = r.buf[:n]
+		} else {
+			p = make([]byte, n)
+		}
+
+		if read, err := r.ra.ReadAt(p, r.base+r.pos); int64(read) < n {
+			if err == nil || err == io.EOF {
+				err = &BoundsError{Offset: r.pos, Size: n, Length: r.length}
+			}
+			r.err = err
+			return nil
+		}
+	}
+
+	r.pos += n
+	return p
+}
+
+// Bytes returns a copy of the next n bytes
+func (r *Reader) Bytes(n int64) []byte {
+	p := r.read(n)
+	if p == nil {
+		return nil
+	}
+
+	result := make([]byte, len(p))
+	copy(result, p)
+	return result
+}
+
+// String reads n bytes and returns them up to the first NUL
+func (r *Reader) String(n int64) string {
+	p := r.read(n)
+	for i, b := range p {
+		if b == 0 {
+			return string(p[:i])
+		}
+	}
+
+	return string(p)
+}
+
+func (r *Reader) uint(n int, endianness Endianness) uint64 {
+	v, _ := GetUint64(endianness, r.read(int64(n)), 0, n)
+	return v
+}
+
+func (r *Reader) Uint8() uint8 {
+	return uint8(r.uint(1, r.Endianness))
+}
+
+func (r *Reader) Uint16() uint16 {
+	return uint16(r.uint(2, r.Endianness))
+}
+
+func (r *Reader) Uint32() uint32 {
+	return uint32(r.uint(4, r.Endianness))
+}
+
+func (r *Reader) Uint64() uint64 {
+	return r.uint(8, r.Endianness)
+}
+
+func (r *Reader) Int8() int8 {
+	return int8(r.Uint8())
+}
+
+func (r *Reader) Int16() int16 {
+	return int16(r.Uint16())
+}
+
+func (r *Reader) Int32() int32 {
+	return int32(r.Uint32())
+}
+
+func (r *Reader) Int64() int64 {
+	return int64(r.Uint64())
+}
+
+func (r *Reader) Uint16Le() uint16 {
+	return uint16(r.uint(2, LittleEndian))
+}
+
+func (r *Reader) Uint32Le() uint32 {
+	return uint32(r.uint(4, LittleEndian))
+}
+
+func (r *Reader) Uint64Le() uint64 {
+	return r.uint(8, LittleEndian)
+}
+
+func (r *Reader) Uint16Be() uint16 {
+	return uint16(r.uint(2, BigEndian))
+}
+
+func (r *Reader) Uint32Be() uint32 {
+	return uint32(r.uint(4, BigEndian))
+}
+
+func (r *Reader) Uint64Be() uint64 {
+	return r.uint(8, BigEndian)
+}
@@ -0,0 +1,96 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	// ELF identification followed by the start of a big endian header
+	data, err := hex.DecodeString("7F454C46020201000000000000000000000200160000000100000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*Reader{
+		NewReader(data, LittleEndian),
+		NewReaderAt(bytes.NewReader(data), int64(len(data)), LittleEndian),
+	} {
+		if v := r.Uint32Be(); v != 0x7F454C46 {
+			t.Fatalf("bad magic %x", v)
+		}
+		if class := r.Uint8(); class != 2 {
+			t.Fatalf("bad class %v", class)
+		}
+		if r.Uint8() == 2 {
+			r.Endianness = BigEndian
+		}
+		r.Seek(16, io.SeekStart)
+		if v := r.Uint16(); v != 2 {
+			t.Fatalf("bad type %v", v)
+		}
+		if v := r.Uint16(); v != 0x16 {
+			t.Fatalf("bad machine %v", v)
+		}
+		if v := r.Int32(); v != 1 {
+			t.Fatalf("bad version %v", v)
+		}
+		if r.Err() != nil || r.Remaining() != 4 {
+			t.Fatalf("bad position %v %v", r.Pos(), r.Err())
+		}
+
+		r.Align(4)
+		if r.Pos() != 24 {
+			t.Fatalf("bad align %v", r.Pos())
+		}
+
+		// Past the end, the error is kept and reads return zero
+		if v := r.Uint64(); v != 0 || !errors.Is(r.Err(), ErrOutOfBounds) {
+			t.Fatalf("bad out of bounds read %v %v", v, r.Err())
+		}
+		first := r.Err()
+		r.Seek(0, io.SeekStart)
+		if v := r.Uint8(); v != 0 || r.Err() != first {
+			t.Fatalf("bad sticky error %v %v", v, r.Err())
+		}
+	}
+
+	r := NewReader(data, BigEndian)
+	r.Skip(4)
+	ident := r.Sub(12)
+	if r.Pos() != 16 || ident.Len() != 12 {
+		t.Fatalf("bad sub %v %v", r.Pos(), ident.Len())
+	}
+	if v := ident.Bytes(3); !bytes.Equal(v, []byte{2, 2, 1}) {
+		t.Fatalf("bad sub bytes %v", v)
+	}
+	ident.Skip(9)
+	if ident.Uint8(); ident.Err() == nil || r.Err() != nil {
+		t.Fatalf("bad sub bounds %v %v", ident.Err(), r.Err())
+	}
+
+	if w := r.Window(0, 4); w.String(4) != "\x7FELF" || r.Pos() != 16 {
+		t.Fatalf("bad window %v", r.Pos())
+	}
+	if w := r.Window(24, 8); !errors.Is(w.Err(), ErrOutOfBounds) || r.Err() != nil {
+		t.Fatalf("bad window bounds %v", w.Err())
+	}
+	if _, err := r.Seek(-1, io.SeekEnd); err != nil || r.Uint8() != 0 || r.Remaining() != 0 {
+		t.Fatalf("bad seek %v", err)
+	}
+	if _, err := r.Seek(1, io.SeekCurrent); !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("bad seek bounds %v", err)
+	}
+
+	r = NewReader(data, BigEndian)
+	r.Skip(20)
+	if r.Align(8); r.Pos() != 24 || r.Err() != nil {
+		t.Fatalf("bad align %v", r.Pos())
+	}
+	if r.Align(16); !errors.Is(r.Err(), ErrOutOfBounds) {
+		t.Fatalf("bad align bounds %v", r.Err())
+	}
+}
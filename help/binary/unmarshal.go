@@ -0,0 +1,316 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidTarget   = errors.New("unmarshal target is not a pointer to a struct")
+	ErrInvalidTag      = errors.New("invalid binary tag")
+	ErrUnsupportedType = errors.New("unsupported field type")
+)
+
+// FieldError is returned by Unmarshal for the field that could not be decoded
+type FieldError struct {
+	// Path of the field, such as "OptionalHeader.Magic"
+	Field string
+	// Where the field starts, from the start of the outermost struct
+	Offset int64
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %v at offset %v: %v", e.Field, e.Offset, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Options of a `binary` struct tag
+type fieldTag struct {
+	skip bool
+	// Offset from the start of the struct, or -1 to follow the previous field
+	offset int64
+	// Bytes of integers, or of strings
+	size int64
+	// Number of elements of slices, or of bytes of strings, as a number or a field name
+	count      int64
+	countField string
+
+	endianness    Endianness
+	hasEndianness bool
+}
+
+// Parses tags such as `binary:"offset=0x18,size=2,be,count=NumberOfSections"`
+func parseTag(tag string) (fieldTag, error) {
+	result := fieldTag{offset: -1, count: -1}
+	if tag == "-" {
+		result.skip = true
+		return result, nil
+	}
+
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		key, value := option, ""
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+
+		var err error
+		switch key {
+		case "":
+		case "le":
+			result.endianness, result.hasEndianness = LittleEndian, true
+		case "be":
+			result.endianness, result.hasEndianness = BigEndian, true
+		case "offset":
+			result.offset, err = parseTagNumber(value)
+		case "size":
+			result.size, err = parseTagNumber(value)
+			if err == nil && result.size == 0 {
+				err = fmt.Errorf("%w: zero size", ErrInvalidTag)
+			}
+		case "count":
+			if result.count, err = parseTagNumber(value); err != nil && value != "" {
+				result.count, result.countField, err = -1, value, nil
+			}
+		default:
+			err = fmt.Errorf("%w: unknown option %q", ErrInvalidTag, option)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func parseTagNumber(value string) (int64, error) {
+	v, err := strconv.ParseInt(value, 0, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%w: bad number %q", ErrInvalidTag, value)
+	}
+
+	return v, nil
+}
+
+// Unmarshal fills the struct v points to from data. Fields are read one after another
+// unless their `binary` tag gives an offset, and integers take the size of their type
+// unless the tag gives one. Tag options, separated by commas, are:
+//
+//	offset=N     the field starts N bytes from the start of the struct
+//	size=N       integers are N bytes, and strings are N bytes with any NULs cut off
+//	le, be       the byte order of the field, overriding endianness
+//	count=N      slices have N elements, and strings N bytes
+//	count=Field  the same, with N read from an earlier integer field
+//	-            the field is not decoded
+//
+// Arrays and nested structs are decoded element by element, with the tag of an array or
+// slice applying to each element. A field outside of data returns a FieldError wrapping
+// ErrOutOfBounds.
+func Unmarshal(data []byte, endianness Endianness, v interface{}) error {
+	return NewReader(data, endianness).Unmarshal(v)
+}
+
+// Unmarshal fills the struct v points to from the position, as the Unmarshal function
+// does, and moves past the last byte decoded. Errors are kept like read errors.
+func (r *Reader) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T", ErrInvalidTarget, v)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	end, err := decodeStruct(r.Window(r.pos, r.Remaining()), rv.Elem(), "", 0)
+	if err != nil {
+		r.err = err
+		return err
+	}
+
+	r.pos += end
+	return nil
+}
+
+// Decodes the fields of v from the start of r, returning where the last field ended.
+// base is the offset of r from the start of the outermost struct.
+func decodeStruct(r *Reader, v reflect.Value, path string, base int64) (int64, error) {
+	t := v.Type()
+	endianness := r.Endianness
+
+	var end int64
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := path + field.Name
+		start := r.Pos()
+		fieldError := func(err error) error {
+			return &FieldError{Field: name, Offset: base + start, Err: err}
+		}
+
+		tag, err := parseTag(field.Tag.Get("binary"))
+		if err != nil {
+			return 0, fieldError(err)
+		}
+		if tag.skip {
+			continue
+		}
+
+		if tag.countField != "" {
+			if tag.count, err = fieldCount(v, tag.countField, i); err != nil {
+				return 0, fieldError(err)
+			}
+		}
+
+		if tag.offset >= 0 {
+			start = tag.offset
+			r.Seek(tag.offset, io.SeekStart)
+		}
+
+		r.Endianness = endianness
+		if tag.hasEndianness {
+			r.Endianness = tag.endianness
+		}
+
+		if err := decodeValue(r, v.Field(i), tag, name, base); err != nil {
+			var nested *FieldError
+			if errors.As(err, &nested) {
+				return 0, err
+			}
+			return 0, fieldError(err)
+		}
+		if r.Err() != nil {
+			return 0, fieldError(r.Err())
+		}
+
+		if r.Pos() > end {
+			end = r.Pos()
+		}
+	}
+
+	return end, nil
+}
+
+// Returns the value of the integer field named name, which must come before field i
+func fieldCount(v reflect.Value, name string, i int) (int64, error) {
+	field, ok := v.Type().FieldByName(name)
+	if !ok || len(field.Index) != 1 || field.Index[0] >= i {
+		return 0, fmt.Errorf("%w: count field %q is not an earlier field", ErrInvalidTag, name)
+	}
+
+	f := v.FieldByIndex(field.Index)
+	switch f.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		if f.Uint() > 1<<62 {
+			return 0, fmt.Errorf("%w: count %v", ErrOutOfBounds, f.Uint())
+		}
+		return int64(f.Uint()), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		if f.Int() < 0 {
+			return 0, fmt.Errorf("%w: count %v", ErrOutOfBounds, f.Int())
+		}
+		return f.Int(), nil
+	}
+
+	return 0, fmt.Errorf("%w: count field %q is not an integer", ErrInvalidTag, name)
+}
+
+func decodeValue(r *Reader, v reflect.Value, tag fieldTag, name string, base int64) error {
+	elementTag := tag
+	elementTag.count = -1
+
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size, err := integerSize(v.Type(), tag)
+		if err != nil {
+			return err
+		}
+		v.SetUint(r.uint(size, r.Endianness))
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size, err := integerSize(v.Type(), tag)
+		if err != nil {
+			return err
+		}
+		// Sign extend from the top bit of the field
+		shift := uint(64 - size*8)
+		v.SetInt(int64(r.uint(size, r.Endianness)<<shift) >> shift)
+	case reflect.Bool:
+		size, err := integerSize(v.Type(), tag)
+		if err != nil {
+			return err
+		}
+		v.SetBool(r.uint(size, r.Endianness) != 0)
+	case reflect.String:
+		size := tag.size
+		if tag.count >= 0 {
+			size = tag.count
+		}
+		if size == 0 && tag.count < 0 {
+			return fmt.Errorf("%w: strings need a size or count", ErrInvalidTag)
+		}
+		v.SetString(r.String(size))
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && tag.size == 0 {
+			copy(v.Slice(0, v.Len()).Bytes(), r.read(int64(v.Len())))
+			return nil
+		}
+		for i := 0; i < v.Len() && r.Err() == nil; i++ {
+			if err := decodeValue(r, v.Index(i), elementTag, fmt.Sprintf("%v[%v]", name, i), base); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if tag.count < 0 {
+			return fmt.Errorf("%w: slices need a count", ErrInvalidTag)
+		}
+		// Every element takes at least a byte, so a count from a corrupt header can not
+		// allocate more than the data
+		if tag.count > r.Remaining() {
+			r.fail(r.Pos(), tag.count)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && tag.size == 0 {
+			v.SetBytes(r.Bytes(tag.count))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), int(tag.count), int(tag.count)))
+		for i := 0; i < v.Len() && r.Err() == nil; i++ {
+			if err := decodeValue(r, v.Index(i), elementTag, fmt.Sprintf("%v[%v]", name, i), base); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		start := r.Pos()
+		end, err := decodeStruct(r.Window(start, r.Remaining()), v, name+".", base+start)
+		if err != nil {
+			return err
+		}
+		r.Skip(end)
+	default:
+		return fmt.Errorf("%w: %v", ErrUnsupportedType, v.Type())
+	}
+
+	return nil
+}
+
+// Returns the size of an integer field, which may be smaller than its type
+func integerSize(t reflect.Type, tag fieldTag) (int, error) {
+	if tag.size == 0 {
+		return int(t.Size()), nil
+	}
+	if tag.size > int64(t.Size()) {
+		return 0, fmt.Errorf("%w: size %v is larger than %v", ErrInvalidTag, tag.size, t)
+	}
+
+	return int(tag.size), nil
+}
@@ -0,0 +1,105 @@
+package binary
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+type testPcapHeader struct {
+	Magic        uint32 `binary:"be"`
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	Network      uint32
+}
+
+type testSection struct {
+	Name   string `binary:"size=8"`
+	Offset uint32
+	Size   int16 `binary:"size=1"`
+}
+
+type testHeader struct {
+	Machine      uint16 `binary:"offset=4"`
+	SectionCount uint8
+	Flags        [2]uint16     `binary:"be"`
+	Sections     []testSection `binary:"count=SectionCount"`
+	NameLength   uint8
+	Name         string  `binary:"count=NameLength"`
+	Marker       [2]byte `binary:"offset=0"`
+	Ignored      uint64  `binary:"-"`
+	unexported   uint64
+}
+
+func TestUnmarshal(t *testing.T) {
+	data, err := hex.DecodeString("a1b2c3d4020004000000000000000000ffff000001000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pcap testPcapHeader
+	if err := Unmarshal(data, LittleEndian, &pcap); err != nil {
+		t.Fatal(err)
+	}
+	if pcap != (testPcapHeader{Magic: 0xA1B2C3D4, VersionMajor: 2, VersionMinor: 4, SnapLen: 0xFFFF, Network: 1}) {
+		t.Fatalf("bad pcap header %+v", pcap)
+	}
+
+	data, err = hex.DecodeString("4d5a0000" + "4c01" + "02" + "00010002" +
+		"2e74657874000000" + "00100000" + "ff" +
+		"2e64617461000000" + "00200000" + "10" +
+		"03" + "616263" + "99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header testHeader
+	r := NewReader(data, LittleEndian)
+	if err := r.Unmarshal(&header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Machine != 0x14C || header.Flags != [2]uint16{1, 2} || header.Name != "abc" || header.Marker != [2]byte{'M', 'Z'} {
+		t.Fatalf("bad header %+v", header)
+	}
+	if len(header.Sections) != 2 || header.Sections[0] != (testSection{".text", 0x1000, -1}) || header.Sections[1] != (testSection{".data", 0x2000, 16}) {
+		t.Fatalf("bad sections %+v", header.Sections)
+	}
+	if v := r.Uint8(); r.Err() != nil || v != 0x99 {
+		t.Fatalf("bad position after unmarshal %v", r.Pos())
+	}
+
+	// A section past the end reports the field it failed at
+	var fieldErr *FieldError
+	err = Unmarshal(data[:34], LittleEndian, &header)
+	if !errors.As(err, &fieldErr) || !errors.Is(err, ErrOutOfBounds) || fieldErr.Field != "Sections[1].Offset" || fieldErr.Offset != 32 {
+		t.Fatalf("bad bounds error %v", err)
+	}
+
+	// Counts from corrupt headers are checked before allocating
+	corrupt := append([]byte{}, data...)
+	corrupt[6] = 0xFF
+	if err := Unmarshal(corrupt, LittleEndian, &header); !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("bad count error %v", err)
+	}
+
+	var badTag struct {
+		Value uint16 `binary:"size=4"`
+	}
+	if err := Unmarshal(data, LittleEndian, &badTag); !errors.As(err, &fieldErr) || !errors.Is(err, ErrInvalidTag) || fieldErr.Field != "Value" {
+		t.Fatalf("bad tag error %v", err)
+	}
+
+	var badType struct {
+		Value *uint16
+	}
+	if err := Unmarshal(data, LittleEndian, &badType); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("bad type error %v", err)
+	}
+
+	if err := Unmarshal(data, LittleEndian, header); !errors.Is(err, ErrInvalidTarget) {
+		t.Fatalf("bad target error %v", err)
+	}
+}
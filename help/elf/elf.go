@@ -0,0 +1,437 @@
+package elf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+	"github.com/gdcorp-infosec/threat-util/help/shannonentropy"
+)
+
+var ErrNotElf = errors.New("not an ELF file")
+var ErrTruncated = errors.New("truncated ELF file")
+
+// Upper bounds on table sizes, to keep malformed files from exhausting memory
+const (
+	MaxProgramHeaders = 4096
+	MaxSections       = 4096
+	MaxDynamicEntries = 65536
+)
+
+const (
+	ptDynamic = 2
+	ptInterp  = 3
+	ptLoad    = 1
+
+	shtSymtab  = 2
+	shtStrtab  = 3
+	shtDynamic = 6
+	shtNobits  = 8
+
+	dtNull   = 0
+	dtNeeded = 1
+	dtStrtab = 5
+	dtSoname = 14
+)
+
+var types = map[uint16]string{
+	0: "none",
+	1: "relocatable",
+	2: "executable",
+	3: "shared object",
+	4: "core",
+}
+
+var machines = map[uint16]string{
+	2:   "sparc",
+	3:   "x86",
+	8:   "mips",
+	20:  "powerpc",
+	21:  "powerpc64",
+	22:  "s390",
+	40:  "arm",
+	43:  "sparcv9",
+	62:  "x86-64",
+	183: "aarch64",
+	243: "riscv",
+}
+
+type Section struct {
+	Name    string
+	Type    uint32
+	Flags   uint64
+	Address uint64
+	Offset  uint64
+	Size    uint64
+	Entropy float64
+}
+
+type ProgramHeader struct {
+	Type     uint32
+	Flags    uint32
+	Offset   uint64
+	Address  uint64
+	FileSize uint64
+	MemSize  uint64
+}
+
+type File struct {
+	Class      int
+	Endianness string
+	OsAbi      uint8
+	Type       string
+	TypeId     uint16
+	Machine    string
+	MachineId  uint16
+	EntryPoint uint64
+
+	Interpreter string   `json:",omitempty"`
+	Soname      string   `json:",omitempty"`
+	Needed      []string `json:",omitempty"`
+
+	ProgramHeaders []ProgramHeader `json:",omitempty"`
+	Sections       []Section       `json:",omitempty"`
+
+	Stripped         bool
+	StaticallyLinked bool
+}
+
+type parser struct {
+	data       []byte
+	endianness binary.Endianness
+	is64       bool
+	file       *File
+}
+
+func IsElf(data []byte) bool {
+	v, ok := binary.Uint32Be(data, 0)
+	return ok && v == 0x7F454C46
+}
+
+func (p *parser) uint(offset int, numBytes int) (uint64, bool) {
+	return binary.GetUint64(p.endianness, p.data, offset, numBytes)
+}
+
+// Reads a field that is 4 bytes in 32-bit files and 8 bytes in 64-bit files
+func (p *parser) word(offset int) (uint64, bool) {
+	if p.is64 {
+		return p.uint(offset, 8)
+	}
+	return p.uint(offset, 4)
+}
+
+func cString(data []byte, offset uint64) string {
+	if offset >= uint64(len(data)) {
+		return ""
+	}
+
+	end := offset
+	for end < uint64(len(data)) && data[end] != 0 {
+		end++
+	}
+
+	return string(data[offset:end])
+}
+
+// Returns the part of [offset, offset+size) present in the file
+func (p *parser) slice(offset uint64, size uint64) []byte {
+	if offset >= uint64(len(p.data)) {
+		return nil
+	}
+
+	end := offset + size
+	if end > uint64(len(p.data)) || end < offset {
+		end = uint64(len(p.data))
+	}
+
+	return p.data[offset:end]
+}
+
+// Parse reads the ELF header, program headers and section headers, along with the
+// interpreter and the libraries listed in the dynamic section
+func Parse(data []byte) (*File, error) {
+	if !IsElf(data) || len(data) < 16 {
+		return nil, ErrNotElf
+	}
+
+	p := &parser{data: data, file: &File{}}
+	f := p.file
+
+	switch data[4] {
+	case 1:
+		f.Class = 32
+	case 2:
+		f.Class = 64
+		p.is64 = true
+	default:
+		return nil, fmt.Errorf("unknown ELF class %v", data[4])
+	}
+
+	switch data[5] {
+	case 1:
+		p.endianness = binary.LittleEndian
+		f.Endianness = "little"
+	case 2:
+		p.endianness = binary.BigEndian
+		f.Endianness = "big"
+	default:
+		return nil, fmt.Errorf("unknown ELF data encoding %v", data[5])
+	}
+
+	f.OsAbi = data[7]
+
+	typeId, ok1 := p.uint(16, 2)
+	machineId, ok2 := p.uint(18, 2)
+	entry, ok3 := p.word(24)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, ErrTruncated
+	}
+
+	f.TypeId = uint16(typeId)
+	f.Type = types[f.TypeId]
+	if f.Type == "" {
+		f.Type = fmt.Sprintf("unknown (0x%04x)", typeId)
+	}
+	f.MachineId = uint16(machineId)
+	f.Machine = machines[f.MachineId]
+	if f.Machine == "" {
+		f.Machine = fmt.Sprintf("unknown (%v)", machineId)
+	}
+	f.EntryPoint = entry
+
+	var phoff, shoff uint64
+	var phentsize, phnum, shentsize, shnum, shstrndx uint64
+	var oks [7]bool
+	if p.is64 {
+		phoff, oks[0] = p.uint(32, 8)
+		shoff, oks[1] = p.uint(40, 8)
+		phentsize, oks[2] = p.uint(54, 2)
+		phnum, oks[3] = p.uint(56, 2)
+		shentsize, oks[4] = p.uint(58, 2)
+		shnum, oks[5] = p.uint(60, 2)
+		shstrndx, oks[6] = p.uint(62, 2)
+	} else {
+		phoff, oks[0] = p.uint(28, 4)
+		shoff, oks[1] = p.uint(32, 4)
+		phentsize, oks[2] = p.uint(42, 2)
+		phnum, oks[3] = p.uint(44, 2)
+		shentsize, oks[4] = p.uint(46, 2)
+		shnum, oks[5] = p.uint(48, 2)
+		shstrndx, oks[6] = p.uint(50, 2)
+	}
+	for _, ok := range oks {
+		if !ok {
+			return nil, ErrTruncated
+		}
+	}
+
+	if phoff != 0 {
+		p.parseProgramHeaders(phoff, phentsize, phnum)
+	}
+	if shoff != 0 {
+		p.parseSections(shoff, shentsize, shnum, shstrndx)
+	}
+
+	hasDynamic := false
+	for _, ph := range f.ProgramHeaders {
+		switch ph.Type {
+		case ptInterp:
+			f.Interpreter = cString(p.slice(ph.Offset, ph.FileSize), 0)
+		case ptDynamic:
+			hasDynamic = true
+		}
+	}
+
+	p.parseDynamic()
+
+	f.Stripped = true
+	for _, s := range f.Sections {
+		if s.Type == shtSymtab {
+			f.Stripped = false
+		}
+	}
+
+	f.StaticallyLinked = f.Interpreter == "" && len(f.Needed) == 0 && !hasDynamic && (f.TypeId == 2 || f.TypeId == 3)
+
+	return f, nil
+}
+
+func (p *parser) parseProgramHeaders(offset uint64, entsize uint64, count uint64) {
+	if count > MaxProgramHeaders {
+		count = MaxProgramHeaders
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if offset >= uint64(len(p.data)) || offset+i*entsize > uint64(len(p.data)) {
+			return
+		}
+		o := int(offset + i*entsize)
+
+		var ph ProgramHeader
+		var typ, flags uint64
+		var oks [6]bool
+		if p.is64 {
+			typ, oks[0] = p.uint(o, 4)
+			flags, oks[1] = p.uint(o+4, 4)
+			ph.Offset, oks[2] = p.uint(o+8, 8)
+			ph.Address, oks[3] = p.uint(o+16, 8)
+			ph.FileSize, oks[4] = p.uint(o+32, 8)
+			ph.MemSize, oks[5] = p.uint(o+40, 8)
+		} else {
+			typ, oks[0] = p.uint(o, 4)
+			ph.Offset, oks[1] = p.uint(o+4, 4)
+			ph.Address, oks[2] = p.uint(o+8, 4)
+			ph.FileSize, oks[3] = p.uint(o+16, 4)
+			ph.MemSize, oks[4] = p.uint(o+20, 4)
+			flags, oks[5] = p.uint(o+24, 4)
+		}
+		for _, ok := range oks {
+			if !ok {
+				return
+			}
+		}
+
+		ph.Type = uint32(typ)
+		ph.Flags = uint32(flags)
+		p.file.ProgramHeaders = append(p.file.ProgramHeaders, ph)
+	}
+}
+
+func (p *parser) parseSections(offset uint64, entsize uint64, count uint64, shstrndx uint64) {
+	if count > MaxSections {
+		count = MaxSections
+	}
+
+	var nameOffsets []uint64
+	for i := uint64(0); i < count; i++ {
+		if offset >= uint64(len(p.data)) || offset+i*entsize > uint64(len(p.data)) {
+			break
+		}
+		o := int(offset + i*entsize)
+
+		var s Section
+		var name, typ uint64
+		var oks [6]bool
+		name, oks[0] = p.uint(o, 4)
+		typ, oks[1] = p.uint(o+4, 4)
+		if p.is64 {
+			s.Flags, oks[2] = p.uint(o+8, 8)
+			s.Address, oks[3] = p.uint(o+16, 8)
+			s.Offset, oks[4] = p.uint(o+24, 8)
+			s.Size, oks[5] = p.uint(o+32, 8)
+		} else {
+			s.Flags, oks[2] = p.uint(o+8, 4)
+			s.Address, oks[3] = p.uint(o+12, 4)
+			s.Offset, oks[4] = p.uint(o+16, 4)
+			s.Size, oks[5] = p.uint(o+20, 4)
+		}
+		ok := true
+		for _, v := range oks {
+			ok = ok && v
+		}
+		if !ok {
+			break
+		}
+
+		s.Type = uint32(typ)
+		if s.Type != shtNobits {
+			if raw := p.slice(s.Offset, s.Size); len(raw) > 0 {
+				s.Entropy = shannonentropy.GetFromSlice(raw)
+			}
+		}
+
+		nameOffsets = append(nameOffsets, name)
+		p.file.Sections = append(p.file.Sections, s)
+	}
+
+	if shstrndx < uint64(len(p.file.Sections)) {
+		strtab := p.file.Sections[shstrndx]
+		names := p.slice(strtab.Offset, strtab.Size)
+		for i := range p.file.Sections {
+			p.file.Sections[i].Name = cString(names, nameOffsets[i])
+		}
+	}
+}
+
+// Returns the file offset of a virtual address using the loadable segments
+func (p *parser) addressToOffset(address uint64) (uint64, bool) {
+	for _, ph := range p.file.ProgramHeaders {
+		if ph.Type == ptLoad && address >= ph.Address && address-ph.Address < ph.FileSize {
+			return address - ph.Address + ph.Offset, true
+		}
+	}
+
+	return 0, false
+}
+
+func (p *parser) parseDynamic() {
+	// Prefer the dynamic section, whose link gives the string table directly
+	var dynamic []byte
+	var strtab []byte
+	for _, s := range p.file.Sections {
+		if s.Type == shtDynamic {
+			dynamic = p.slice(s.Offset, s.Size)
+			break
+		}
+	}
+
+	if dynamic == nil {
+		for _, ph := range p.file.ProgramHeaders {
+			if ph.Type == ptDynamic {
+				dynamic = p.slice(ph.Offset, ph.FileSize)
+				break
+			}
+		}
+	}
+
+	if dynamic == nil {
+		return
+	}
+
+	entrySize := 8
+	if p.is64 {
+		entrySize = 16
+	}
+
+	var needed []uint64
+	var soname uint64
+	hasSoname := false
+DynamicLoop:
+	for i := 0; i < MaxDynamicEntries && (i+1)*entrySize <= len(dynamic); i++ {
+		tag, _ := binary.GetUint64(p.endianness, dynamic, i*entrySize, entrySize/2)
+		value, _ := binary.GetUint64(p.endianness, dynamic, i*entrySize+entrySize/2, entrySize/2)
+
+		switch tag {
+		case dtNull:
+			break DynamicLoop
+		case dtNeeded:
+			needed = append(needed, value)
+		case dtSoname:
+			soname = value
+			hasSoname = true
+		case dtStrtab:
+			if offset, ok := p.addressToOffset(value); ok {
+				strtab = p.slice(offset, uint64(len(p.data)))
+			}
+		}
+	}
+
+	if strtab == nil {
+		for _, s := range p.file.Sections {
+			if s.Type == shtStrtab && s.Name == ".dynstr" {
+				strtab = p.slice(s.Offset, s.Size)
+			}
+		}
+	}
+
+	if strtab == nil {
+		return
+	}
+
+	for _, offset := range needed {
+		p.file.Needed = append(p.file.Needed, cString(strtab, offset))
+	}
+	if hasSoname {
+		p.file.Soname = cString(strtab, soname)
+	}
+}
@@ -0,0 +1,68 @@
+package elf_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/elf"
+)
+
+func TestElf(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dynamic.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := elf.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range f.Sections {
+		names = append(names, s.Name)
+	}
+
+	if f.Class != 64 || f.Endianness != "little" || f.Machine != "x86-64" || f.Type != "shared object" {
+		t.Fatalf("bad header: %+v", f)
+	} else if f.Interpreter != "/lib64/ld-linux-x86-64.so.2" {
+		t.Fatalf("bad interpreter: %v", f.Interpreter)
+	} else if !reflect.DeepEqual(f.Needed, []string{"libc.so.6", "libm.so.6"}) {
+		t.Fatalf("bad needed: %v", f.Needed)
+	} else if !reflect.DeepEqual(names, []string{"", ".interp", ".dynstr", ".dynamic", ".shstrtab"}) {
+		t.Fatalf("bad section names: %v", names)
+	} else if !f.Stripped || f.StaticallyLinked {
+		t.Fatalf("bad linking flags")
+	}
+
+	// A loadable segment mapping the string table past the end of the file falls back to
+	// the .dynstr section
+	data = append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(data[0x40+56+8:], 0xFF000000)
+	f, err = elf.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(f.Needed, []string{"libc.so.6", "libm.so.6"}) {
+		t.Fatalf("bad needed: %v", f.Needed)
+	}
+
+	// 32-bit big endian executable with no dynamic linking
+	data = make([]byte, 52)
+	copy(data, "\x7FELF\x01\x02\x01")
+	binary.BigEndian.PutUint16(data[16:], 2)
+	binary.BigEndian.PutUint16(data[18:], 8)
+	binary.BigEndian.PutUint32(data[24:], 0x400000)
+
+	f, err = elf.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.Class != 32 || f.Endianness != "big" || f.Machine != "mips" || f.EntryPoint != 0x400000 || !f.StaticallyLinked {
+		t.Fatalf("bad header: %+v", f)
+	}
+
+	if _, err := elf.Parse([]byte("\x7FELF")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
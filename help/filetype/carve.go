@@ -0,0 +1,600 @@
+package filetype
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+// Signatures with fewer fixed bytes than this match too often by chance to be carved
+const minCarveSignatureBytes = 4
+
+type CarvedFile struct {
+	FileType
+
+	Offset int
+	// Estimated length, zero when the format does not record it
+	Length int
+}
+
+// A signature searched for by the anchor, its longest run of fixed bytes
+type carvePattern struct {
+	entry     *signatureEntry
+	signature *signature
+	anchor    []byte
+	// Offset of the anchor within the signature
+	anchorOffset int
+	// Offset of the signature within the file
+	location int
+}
+
+// Aho-Corasick automaton matching every anchor in a single pass
+type carveMatcher struct {
+	next    [][256]int32
+	outputs [][]int
+	pattern []carvePattern
+}
+
+// Checks a match beyond its signature, returning the estimated length or zero when it is
+// unknown. Matches failing the check are dropped.
+type lengthFunc func(data []byte) (int, bool)
+
+var lengthFuncs = map[Id]lengthFunc{
+	Dos:  peLength,
+	Zip:  zipLength,
+	Pdf:  pdfLength,
+	Png:  pngLength,
+	Jpg:  jpgLength,
+	Gif:  gifLength,
+	Elf:  elfLength,
+	Cab:  cabLength,
+	Wav:  riffLength,
+	Avi:  riffLength,
+	Ico:  icoLength,
+	Tiff: tiffLength,
+
+	Cpio:         cpioLength,
+	Ar:           arLength,
+	Deb:          arLength,
+	RegistryHive: registryHiveLength,
+	Evtx:         evtxLength,
+	PcapNg:       pcapNgLength,
+}
+
+// Markers whose positions end zips and PDFs
+var (
+	zipEndMarker = []byte("PK\x05\x06")
+	pdfEndMarker = []byte("%%EOF")
+)
+
+// Positions of the end markers in the data being carved, each found with one pass when
+// first needed, so that a match does not search the rest of the data again
+type carveMarkers struct {
+	data    []byte
+	offsets map[string][]int
+}
+
+func (m *carveMarkers) find(marker []byte) []int {
+	offsets, ok := m.offsets[string(marker)]
+	if ok {
+		return offsets
+	}
+
+	for i := 0; ; {
+		j := bytes.Index(m.data[i:], marker)
+		if j < 0 {
+			break
+		}
+		offsets = append(offsets, i+j)
+		i += j + 1
+	}
+
+	m.offsets[string(marker)] = offsets
+	return offsets
+}
+
+// Returns the first marker at or after start, relative to start, or -1
+func (m *carveMarkers) first(marker []byte, start int) int {
+	offsets := m.find(marker)
+	if i := sort.SearchInts(offsets, start); i < len(offsets) {
+		return offsets[i] - start
+	}
+
+	return -1
+}
+
+// Returns the last marker at or after start, relative to start, or -1
+func (m *carveMarkers) last(marker []byte, start int) int {
+	offsets := m.find(marker)
+	if len(offsets) > 0 && offsets[len(offsets)-1] >= start {
+		return offsets[len(offsets)-1] - start
+	}
+
+	return -1
+}
+
+// Runs the length function of a match at start, using the marker positions for the types
+// that would otherwise search for them
+func (m *carveMarkers) length(f lengthFunc, id Id, start int) (int, bool) {
+	switch id {
+	case Zip:
+		return zipLengthTo(m.data[start:], m.first(zipEndMarker, start))
+	case Pdf:
+		return pdfLengthTo(m.data[start:], m.last(pdfEndMarker, start))
+	}
+
+	return f(m.data[start:])
+}
+
+// Types whose own structure repeats their signature, such as the local headers of each zip
+// entry, so matches inside an earlier match of the same type are not separate files
+var repeatsSignature = map[Id]bool{
+	Zip:  true,
+	Cpio: true,
+}
+
+func anchorOf(s *signature) ([]byte, int) {
+	var best []byte
+	bestOffset := 0
+	var run []byte
+	runOffset := 0
+	for i := 0; i+1 < len(s.Nibbles); i += 2 {
+		if s.WildcardNibbles[i] || s.WildcardNibbles[i+1] {
+			run = nil
+			continue
+		}
+		if run == nil {
+			runOffset = i / 2
+		}
+		run = append(run, s.Nibbles[i]<<4|s.Nibbles[i+1])
+		if len(run) > len(best) {
+			best = append([]byte(nil), run...)
+			bestOffset = runOffset
+		}
+	}
+
+	return best, bestOffset
+}
+
+func fixedBytes(s *signature) int {
+	count := 0
+	for _, w := range s.WildcardNibbles {
+		if !w {
+			count++
+		}
+	}
+
+	return count / 2
+}
+
+func newCarveMatcher(entries []*signatureEntry) *carveMatcher {
+	m := &carveMatcher{}
+
+	// The MZ header is checked for a PE header by its length function
+	dos := &signatureEntry{FileType: newFile(Dos, "DOS Executable", "application/x-dosexec", "exe")}
+	dosSignature, _ := newSignature("4D 5A")
+	m.pattern = append(m.pattern, carvePattern{entry: dos, signature: dosSignature, anchor: []byte("MZ")})
+
+	for _, e := range entries {
+		for _, s := range e.Signatures {
+			if fixedBytes(s) < minCarveSignatureBytes {
+				continue
+			}
+			anchor, offset := anchorOf(s)
+			if len(s.Locations) == 0 {
+				m.pattern = append(m.pattern, carvePattern{entry: e, signature: s, anchor: anchor, anchorOffset: offset})
+				continue
+			}
+
+			// The first location is the primary one, where files always have the signature.
+			// Signatures found by searching a range do not give the start of the file.
+			if l := s.Locations[0]; l.Start == l.End {
+				m.pattern = append(m.pattern, carvePattern{entry: e, signature: s, anchor: anchor, anchorOffset: offset, location: l.Start})
+			}
+		}
+	}
+
+	// Build the trie
+	m.next = make([][256]int32, 1)
+	m.outputs = make([][]int, 1)
+	for i, p := range m.pattern {
+		node := int32(0)
+		for _, b := range p.anchor {
+			if m.next[node][b] == 0 {
+				m.next = append(m.next, [256]int32{})
+				m.outputs = append(m.outputs, nil)
+				m.next[node][b] = int32(len(m.next) - 1)
+			}
+			node = m.next[node][b]
+		}
+		m.outputs[node] = append(m.outputs[node], i)
+	}
+
+	// Turn it into a DFA by filling missing transitions from the failure links, breadth first
+	fail := make([]int32, len(m.next))
+	var queue []int32
+	for b := 0; b < 256; b++ {
+		if child := m.next[0][b]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		m.outputs[node] = append(m.outputs[node], m.outputs[fail[node]]...)
+
+		for b := 0; b < 256; b++ {
+			child := m.next[node][b]
+			if child == 0 {
+				m.next[node][b] = m.next[fail[node]][b]
+				continue
+			}
+			fail[child] = m.next[fail[node]][b]
+			queue = append(queue, child)
+		}
+	}
+
+	return m
+}
+
+// Carve finds the files embedded anywhere in data, including the one at offset zero, by
+// searching for every signature with enough fixed bytes to be meaningful. Matches are
+// ordered by offset. When maxCount is positive, at most that many are returned and
+// truncated reports whether more were found.
+func Carve(data []byte, maxCount int) (carved []CarvedFile, truncated bool) {
+	return DefaultRegistry.Carve(data, maxCount)
+}
+
+func (r *Registry) Carve(data []byte, maxCount int) (carved []CarvedFile, truncated bool) {
+	r.mu.RLock()
+	matcher := r.matcher
+	r.mu.RUnlock()
+
+	// End of the last match of each type that repeats its signature
+	regionEnd := map[Id]int{}
+	markers := &carveMarkers{data: data, offsets: map[string][]int{}}
+
+	node := int32(0)
+	for i, b := range data {
+		node = matcher.next[node][b]
+		if len(matcher.outputs[node]) == 0 {
+			continue
+		}
+
+		// Sort the matches ending here by their start so that regions are set in order
+		var starts []CarvedFile
+		for _, index := range matcher.outputs[node] {
+			p := matcher.pattern[index]
+			start := i + 1 - len(p.anchor) - p.anchorOffset - p.location
+			if start < 0 || !p.signature.matchesAt(data[start:], p.location) {
+				continue
+			}
+			if check, ok := structureChecks[p.entry.FileTypeId]; ok && !check(data[start:]) {
+				continue
+			}
+
+			c := CarvedFile{FileType: p.entry.FileType, Offset: start}
+			c.Confidence = signatureConfidence(p.signature)
+			if _, ok := structureChecks[p.entry.FileTypeId]; ok {
+				c.Confidence = validConfidence(c.Confidence)
+			}
+			if f, ok := lengthFuncs[p.entry.FileTypeId]; ok {
+				length, ok := markers.length(f, p.entry.FileTypeId, start)
+				if !ok {
+					continue
+				}
+				c.Length = length
+				c.Confidence = validConfidence(c.Confidence)
+			}
+			if c.FileTypeId == Dos {
+				c.FileType = withConfidence(peType, confidencePe)
+			}
+			starts = append(starts, c)
+		}
+		sort.SliceStable(starts, func(a, b int) bool { return starts[a].Offset < starts[b].Offset })
+
+		for _, c := range starts {
+			if repeatsSignature[c.FileTypeId] {
+				if c.Offset < regionEnd[c.FileTypeId] {
+					continue
+				}
+				if c.Length > 0 {
+					regionEnd[c.FileTypeId] = c.Offset + c.Length
+				}
+			}
+
+			if maxCount > 0 && len(carved) >= maxCount {
+				return carved, true
+			}
+			carved = append(carved, c)
+		}
+	}
+
+	// Anchors end at different places, so matches are found slightly out of order
+	sort.SliceStable(carved, func(a, b int) bool { return carved[a].Offset < carved[b].Offset })
+
+	return carved, false
+}
+
+// Returns the end of the last section's raw data, or SizeOfImage when there are no sections
+func peLength(p []byte) (int, bool) {
+	peOffset, ok := binary.Uint32Le(p, 0x3C)
+	if !ok || peOffset > 0x10000 {
+		return 0, false
+	}
+	if v, ok := binary.Uint32Be(p, int(peOffset)); !ok || v != 0x50450000 {
+		return 0, false
+	}
+
+	numSections, ok1 := binary.Uint16Le(p, int(peOffset)+6)
+	optionalHeaderSize, ok2 := binary.Uint16Le(p, int(peOffset)+20)
+	sizeOfImage, ok3 := binary.Uint32Le(p, int(peOffset)+24+56)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, true
+	}
+
+	end := 0
+	sections := int(peOffset) + 24 + int(optionalHeaderSize)
+	for i := 0; i < int(numSections); i++ {
+		rawSize, ok1 := binary.Uint32Le(p, sections+i*40+16)
+		rawOffset, ok2 := binary.Uint32Le(p, sections+i*40+20)
+		if !ok1 || !ok2 {
+			break
+		}
+		if e := int(rawOffset) + int(rawSize); e > end {
+			end = e
+		}
+	}
+
+	if end == 0 {
+		end = int(sizeOfImage)
+	}
+
+	return end, true
+}
+
+// Returns the end of the end of central directory record, including its comment
+func zipLength(p []byte) (int, bool) {
+	return zipLengthTo(p, bytes.Index(p, zipEndMarker))
+}
+
+// Returns the end of the end of central directory record at eocd, or zero when it is -1
+func zipLengthTo(p []byte, eocd int) (int, bool) {
+	switch {
+	case bytes.HasPrefix(p, zipEndMarker):
+		// An empty zip is just the end of central directory record
+	case bytes.HasPrefix(p, []byte("PK\x07\x08")):
+		// The spanning marker of split archives comes before the first local file header
+		if !bytes.HasPrefix(p[4:], []byte("PK\x03\x04")) {
+			return 0, false
+		}
+	case !bytes.HasPrefix(p, []byte("PK\x03\x04")):
+		return 0, false
+	}
+
+	if eocd < 0 {
+		return 0, true
+	}
+
+	commentLength, ok := binary.Uint16Le(p, eocd+20)
+	if !ok {
+		return 0, true
+	}
+
+	return eocd + 22 + int(commentLength), true
+}
+
+// Returns the end of the last %%EOF marker, since incremental updates append several
+func pdfLength(p []byte) (int, bool) {
+	return pdfLengthTo(p, bytes.LastIndex(p, pdfEndMarker))
+}
+
+// Returns the end of the %%EOF marker at eof, or zero when it is -1
+func pdfLengthTo(p []byte, eof int) (int, bool) {
+	if eof < 0 {
+		return 0, true
+	}
+
+	end := eof + 5
+	for end < len(p) && (p[end] == '\r' || p[end] == '\n') {
+		end++
+	}
+
+	return end, true
+}
+
+// Walks the chunks up to IEND
+func pngLength(p []byte) (int, bool) {
+	for offset := 8; ; {
+		length, ok1 := binary.Uint32Be(p, offset)
+		typ, ok2 := binary.Uint32Be(p, offset+4)
+		if !ok1 || !ok2 || length > 0x7FFFFFFF {
+			return 0, true
+		}
+
+		offset += 12 + int(length)
+		if typ == 0x49454E44 {
+			return offset, true
+		}
+	}
+}
+
+// Walks the segments up to the scan data, then looks for the end of image marker
+func jpgLength(p []byte) (int, bool) {
+	offset := 2
+	for {
+		if offset+4 > len(p) || p[offset] != 0xFF {
+			return 0, true
+		}
+
+		marker := p[offset+1]
+		if marker == 0xD9 {
+			return offset + 2, true
+		}
+		length, _ := binary.Uint16Be(p, offset+2)
+		offset += 2 + int(length)
+
+		if marker == 0xDA {
+			break
+		}
+	}
+
+	// Within scan data, 0xFF is followed by a stuffed zero or a restart marker
+	for ; offset+1 < len(p); offset++ {
+		if p[offset] != 0xFF {
+			continue
+		}
+		switch next := p[offset+1]; {
+		case next == 0xD9:
+			return offset + 2, true
+		case next == 0x00 || next == 0xFF || (next >= 0xD0 && next <= 0xD7):
+		default:
+			// Progressive images have further segments and scans
+			if length, ok := binary.Uint16Be(p, offset+2); ok {
+				offset += 1 + int(length)
+			}
+		}
+	}
+
+	return 0, true
+}
+
+// Walks the blocks up to the trailer
+func gifLength(p []byte) (int, bool) {
+	flags, ok := binary.Uint8Le(p, 10)
+	if !ok {
+		return 0, false
+	}
+
+	offset := 13
+	if flags&0x80 != 0 {
+		offset += 3 << (flags&0x07 + 1)
+	}
+
+	skipSubBlocks := func() bool {
+		for offset < len(p) {
+			size := int(p[offset])
+			offset++
+			if size == 0 {
+				return true
+			}
+			offset += size
+		}
+		return false
+	}
+
+	for offset < len(p) {
+		switch p[offset] {
+		case 0x3B:
+			return offset + 1, true
+		case 0x21:
+			offset += 2
+		case 0x2C:
+			localFlags, ok := binary.Uint8Le(p, offset+9)
+			if !ok {
+				return 0, true
+			}
+			offset += 10
+			if localFlags&0x80 != 0 {
+				offset += 3 << (localFlags&0x07 + 1)
+			}
+			// LZW minimum code size
+			offset++
+		default:
+			return 0, true
+		}
+
+		if !skipSubBlocks() {
+			return 0, true
+		}
+	}
+
+	return 0, true
+}
+
+// Returns the end of the section header table, which linkers place last
+func elfLength(p []byte) (int, bool) {
+	if len(p) < 6 {
+		return 0, false
+	}
+
+	var endianness binary.Endianness
+	switch p[5] {
+	case 1:
+		endianness = binary.LittleEndian
+	case 2:
+		endianness = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	var shoff, shentsize, shnum uint64
+	var ok1, ok2, ok3 bool
+	switch p[4] {
+	case 1:
+		shoff, ok1 = binary.GetUint64(endianness, p, 32, 4)
+		shentsize, ok2 = binary.GetUint64(endianness, p, 46, 2)
+		shnum, ok3 = binary.GetUint64(endianness, p, 48, 2)
+	case 2:
+		shoff, ok1 = binary.GetUint64(endianness, p, 40, 8)
+		shentsize, ok2 = binary.GetUint64(endianness, p, 58, 2)
+		shnum, ok3 = binary.GetUint64(endianness, p, 60, 2)
+	default:
+		return 0, false
+	}
+
+	if !ok1 || !ok2 || !ok3 || shoff == 0 || shoff > uint64(len(p)) {
+		return 0, true
+	}
+
+	return int(shoff + shentsize*shnum), true
+}
+
+func cabLength(p []byte) (int, bool) {
+	size, ok := binary.Uint32Le(p, 8)
+	return int(size), ok
+}
+
+func riffLength(p []byte) (int, bool) {
+	size, ok := binary.Uint32Le(p, 4)
+	return int(size) + 8, ok
+}
+
+// Icon headers are mostly zero bytes, so check the directory entries
+func icoLength(p []byte) (int, bool) {
+	count, ok := binary.Uint16Le(p, 4)
+	if !ok || count == 0 || count > 256 {
+		return 0, false
+	}
+
+	end := 0
+	for i := 0; i < int(count); i++ {
+		e := 6 + i*16
+		reserved, ok1 := binary.Uint8Le(p, e+3)
+		planes, ok2 := binary.Uint16Le(p, e+4)
+		size, ok3 := binary.Uint32Le(p, e+8)
+		offset, ok4 := binary.Uint32Le(p, e+12)
+		if !ok1 || !ok2 || !ok3 || !ok4 || reserved != 0 || planes > 1 || size == 0 || offset < uint32(6+16*int(count)) {
+			return 0, false
+		}
+		if e := int(offset) + int(size); e > end {
+			end = e
+		}
+	}
+
+	return end, true
+}
+
+// The first image file directory must fall inside the data
+func tiffLength(p []byte) (int, bool) {
+	var offset uint32
+	var ok bool
+	if p[0] == 'I' {
+		offset, ok = binary.Uint32Le(p, 4)
+	} else {
+		offset, ok = binary.Uint32Be(p, 4)
+	}
+
+	return 0, ok && offset >= 8 && int(offset) < len(p)
+}
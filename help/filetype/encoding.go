@@ -0,0 +1,220 @@
+package filetype
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+// Text encodings reported in FileType.Encoding
+const (
+	EncodingAscii   = "ASCII"
+	EncodingUtf8    = "UTF-8"
+	EncodingUtf8Bom = "UTF-8 BOM"
+	EncodingUtf16Le = "UTF-16LE"
+	EncodingUtf16Be = "UTF-16BE"
+	EncodingUtf32Le = "UTF-32LE"
+	EncodingUtf32Be = "UTF-32BE"
+)
+
+// Bytes looked at when guessing the encoding of text without a BOM
+const maxEncodingProbe = 4096
+
+var byteOrderMarks = []struct {
+	Bom      []byte
+	Encoding string
+}{
+	// UTF-32LE first, since its BOM starts with the UTF-16LE one
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, EncodingUtf32Le},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, EncodingUtf32Be},
+	{[]byte{0xEF, 0xBB, 0xBF}, EncodingUtf8Bom},
+	{[]byte{0xFF, 0xFE}, EncodingUtf16Le},
+	{[]byte{0xFE, 0xFF}, EncodingUtf16Be},
+}
+
+// Bytes at the start of data that decide whether it is text, and that are decoded for
+// the text heuristics
+const maxTextProbe = 64 * 1024
+
+// What Get finds out about data as text, once for every check that needs it
+type textProbe struct {
+	isText   bool
+	encoding string
+	// The probed bytes decoded, without any BOM
+	text      string
+	bomLength int
+	// Whether the probe covered all of the data
+	complete bool
+}
+
+// Probes the start of p for text. Encodings come from a BOM, from the pattern of NULs in
+// UTF-16 and UTF-32 text that is mostly ASCII, or from p being valid UTF-8 without NULs.
+func probeText(p []byte) textProbe {
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(p, bom.Bom) {
+			probe := textProbe{encoding: bom.Encoding, bomLength: len(bom.Bom)}
+			text, ok := decodeAs(probe.cut(p[len(bom.Bom):]), bom.Encoding)
+			// A BOM is enough to trust the encoding, so allow any control characters
+			probe.text, probe.isText = text, ok && !hasNul(text)
+			return probe
+		}
+	}
+
+	if encoding, ok := guessWideEncoding(p); ok {
+		probe := textProbe{encoding: encoding}
+		text, ok := decodeAs(probe.cut(p), encoding)
+		probe.text, probe.isText = text, ok && isPlainText(text)
+		return probe
+	}
+
+	probe := textProbe{encoding: EncodingAscii}
+	prefix := probe.cut(p)
+	if bytes.IndexByte(prefix, 0) >= 0 || !utf8.Valid(prefix) {
+		return textProbe{}
+	}
+
+	for _, b := range prefix {
+		if b >= 0x80 {
+			probe.encoding = EncodingUtf8
+			break
+		}
+	}
+
+	probe.text, probe.isText = string(prefix), true
+	return probe
+}
+
+// Returns the first maxTextProbe bytes of p, leaving out a character the limit cuts through
+func (t *textProbe) cut(p []byte) []byte {
+	if len(p) <= maxTextProbe {
+		t.complete = true
+		return p
+	}
+	p = p[:maxTextProbe]
+
+	switch t.encoding {
+	case EncodingUtf16Le, EncodingUtf16Be:
+		endianness := binary.LittleEndian
+		if t.encoding == EncodingUtf16Be {
+			endianness = binary.BigEndian
+		}
+		if unit, _ := binary.GetUint64(endianness, p, len(p)-2, 2); unit >= 0xD800 && unit < 0xDC00 {
+			p = p[:len(p)-2]
+		}
+	case EncodingUtf32Le, EncodingUtf32Be:
+	default:
+		for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(p[i]) {
+				if !utf8.FullRune(p[i:]) {
+					p = p[:i]
+				}
+				break
+			}
+		}
+	}
+
+	return p
+}
+
+func decodeAs(p []byte, encoding string) (string, bool) {
+	switch encoding {
+	case EncodingUtf8Bom:
+		if !utf8.Valid(p) {
+			return "", false
+		}
+		return string(p), true
+	case EncodingUtf16Le, EncodingUtf16Be:
+		endianness := binary.LittleEndian
+		if encoding == EncodingUtf16Be {
+			endianness = binary.BigEndian
+		}
+
+		units := make([]uint16, 0, len(p)/2)
+		for i := 0; i+1 < len(p); i += 2 {
+			v, _ := binary.GetUint64(endianness, p, i, 2)
+			units = append(units, uint16(v))
+		}
+
+		// Decode would replace unpaired surrogates, which text does not have
+		for i := 0; i < len(units); i++ {
+			switch {
+			case utf16.IsSurrogate(rune(units[i])) && units[i] < 0xDC00 && i+1 < len(units) && units[i+1] >= 0xDC00 && units[i+1] < 0xE000:
+				i++
+			case utf16.IsSurrogate(rune(units[i])):
+				return "", false
+			}
+		}
+		return string(utf16.Decode(units)), true
+	case EncodingUtf32Le, EncodingUtf32Be:
+		endianness := binary.LittleEndian
+		if encoding == EncodingUtf32Be {
+			endianness = binary.BigEndian
+		}
+
+		runes := make([]rune, 0, len(p)/4)
+		for i := 0; i+3 < len(p); i += 4 {
+			v, _ := binary.GetUint64(endianness, p, i, 4)
+			if !utf8.ValidRune(rune(v)) {
+				return "", false
+			}
+			runes = append(runes, rune(v))
+		}
+		return string(runes), true
+	}
+
+	return "", false
+}
+
+// Text that is mostly ASCII has NULs in the high bytes of every UTF-16 or UTF-32 code
+// unit, and rarely elsewhere
+func guessWideEncoding(p []byte) (string, bool) {
+	if len(p) > maxEncodingProbe {
+		p = p[:maxEncodingProbe]
+	}
+	if len(p) < 4 {
+		return "", false
+	}
+
+	var zeros [4]int
+	for i, b := range p {
+		if b == 0 {
+			zeros[i%4]++
+		}
+	}
+	units := len(p) / 4
+
+	mostly := func(n int) bool { return n*10 >= units*9 }
+	rarely := func(n int) bool { return n*10 <= units }
+
+	switch {
+	case rarely(zeros[0]) && mostly(zeros[1]) && mostly(zeros[2]) && mostly(zeros[3]):
+		return EncodingUtf32Le, true
+	case mostly(zeros[0]) && mostly(zeros[1]) && mostly(zeros[2]) && rarely(zeros[3]):
+		return EncodingUtf32Be, true
+	case rarely(zeros[0]) && rarely(zeros[2]) && mostly(zeros[1]) && mostly(zeros[3]):
+		return EncodingUtf16Le, true
+	case mostly(zeros[0]) && mostly(zeros[2]) && rarely(zeros[1]) && rarely(zeros[3]):
+		return EncodingUtf16Be, true
+	}
+
+	return "", false
+}
+
+func hasNul(text string) bool {
+	return strings.IndexByte(text, 0) >= 0
+}
+
+// Without a BOM, binary data with the right NUL pattern is told apart by its control
+// characters
+func isPlainText(text string) bool {
+	for _, r := range text {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\v' {
+			return false
+		}
+	}
+
+	return true
+}
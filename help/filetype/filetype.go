@@ -2,13 +2,17 @@ package filetype
 
 import (
 	"errors"
+	"math"
 	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/gdcorp-infosec/threat-util/help/binary"
+	"github.com/gdcorp-infosec/threat-util/help/macho"
 )
 
+// Id identifies a file type. Ids are marshaled as stable names, so constants may be
+// added anywhere, although new ones go at the end to keep old numeric results readable.
 type Id uint
 
 const (
@@ -54,13 +58,64 @@ const (
 	Rtf
 	Tiff
 	OpenOfficeXml
+	Docx
+	Docm
+	Xlsx
+	Xlsm
+	Pptx
+	Pptm
+	OpenDocument
+	Jar
+	Apk
+	BrowserExtension
+	ShellScript
+	Html
+	Xml
+	Svg
+	Json
+	PowerShell
+	Batch
+	JavaScript
+	VbScript
+	Hta
+	Python
+	Xz
+	Zstd
+	Lz4
+	Lnk
+	Msi
+	Chm
+	OneNote
+	Vhd
+	Vhdx
+	Wim
+	Cpio
+	Rpm
+	Ar
+	Deb
+	RegistryHive
+	Evtx
+	PcapNg
+	Pyc
 )
 
 type signature struct {
 	Nibbles         []byte
 	WildcardNibbles []bool
+	// Where the signature may start, at offset zero when empty
+	Locations []location
 }
 
+// A range of offsets, both inclusive, that a signature is searched at
+type location struct {
+	Start int
+	End   int
+}
+
+// Upper bound on the offsets a signature is searched at, to keep a bad range from making
+// every detection slow
+const maxSearchRange = 64 * 1024
+
 type signatureEntry struct {
 	FileType
 
@@ -71,49 +126,76 @@ type FileType struct {
 	FileTypeId  Id
 	Description string
 	Extensions  []string
+	MimeType    string
+	// How likely the type is to be right, from 0 to 1, based on the length of the signature,
+	// structural checks and whether the type is a fallback such as Binary
+	Confidence float64
+	// Set for types found by heuristics rather than signatures, naming the one that fired
+	Heuristic string `json:",omitempty"`
+	// Set for text types, one of the Encoding constants
+	Encoding string `json:",omitempty"`
 }
 
 type FileTypes []FileType
 
+// Each entry is "signatures|description|extensions|MIME type", with signatures and
+// extensions separated by commas
 type signatureTableEntry struct {
 	Fmt        string
 	FileTypeId Id
 }
 
 var signatureTable = []signatureTableEntry{
-	{"7F 45 4C 46 |ELF Executable|", Elf},
-	{"a1 b2 c3 d4, d4 c3 b2 a1|Packet Capture|pcap", Pcap},
-	{"25 50 44 46 2d|PDF Document|pdf", Pdf},
-	{"53 51 4c 69 74 65 20 66 6f 72 6d 61 74 20 33 00|SQLite Database|db", SqliteDatabase},
-	{"00 00 01 00|Computer Icon|ico", Ico},
-	{"47 49 46 38 37 61,47 49 46 38 39 61|GIF Image|gif", Gif},
-	{"89 50 4E 47 0D 0A 1A 0A|Portable Network Graphics|png", Png},
-	{"CA FE BA BE,FE ED FA CE,FE ED FA CF,CE FA ED FE,CF FA ED FE|Mach-O Binary|", Macho},
-	{"CA FE BA BE|Java Class|class", JavaClass},
-	{"52 49 46 46 ?? ?? ?? ?? 57 41 56 45|Waveform Audio File|wav", Wav},
-	{"52 49 46 46 ?? ?? ?? ?? 41 56 49 20|Audio Video Interleave|avi", Avi},
-	{"FF FB|MP3 File|mp3", Mp3},
-	{"D0 CF 11 E0 A1 B1 1A E1|Office Document|doc,xls,ppt", Office},
-	{"64 65 78 0A 30 33 35 00|Dalvik Executable|dex", Dalvik},
-	{"75 73 74 61 72 00 30 30,75 73 74 61 72 20 20 00|Tar Archive|tar", Tar},
-	{"37 7A BC AF 27 1C|7-Zip|7z", SevenZip},
-	{"1F 8B|Gzip|gz", Gzip},
-	{"43 57 53,46 57 53|Shockwave Flash|swf", Swf},
-	{"FF D8 FF DB,FF D8 FF E0 00 10 4A 46 49 46 00 01,FF D8 FF EE,FF D8 FF E1 ?? ?? 45 78 69 66 00 00|JPEG Image|jpg,jpeg", Jpg},
-	{"50 4B 03 04,50 4B 05 06,50 4B 07 08|Zip Archive|zip", Zip},
-	{"52 61 72 21 1A 07 00,52 61 72 21 1A 07 01 00|RAR Archive|rar", Rar},
-	{"43 44 30 30 31|ISO Image|iso", Iso},
-	{"4F 67 67 53|Ogg Vorbis Data|ogg", Ogg},
-	{"4D 53 43 46|Windows Cabinet Archive|cab", Cab},
-	{"00 61 73 6d|WebAssembly|wasm", Wasm},
-	{"42 5A 68|Bzip2 Data|bz2", Bzip2},
-	{"49 49 2A 00,4D 4D 00 2A|Tagged Image File Format|tiff", Tiff},
-	{"25 21 50 53|PostScript Document|ps", PostScript},
-	{"43 72 32 34|Chrome Extension|crx", ChromeExtension},
-	{"78 01 73 0D 62 62 60|Apple Disk Image|dmg", Dmg},
-	{"7B 5C 72 74 66 31|RTF Document|rtf", Rtf},
-	{"38 42 50 53|PhotoShop Document|psd", PhotoShop},
-	{"78 01,78 9C,78 DA|Zlib Data|zlib", Zlib},
+	{"7F 45 4C 46 |ELF Executable||application/x-executable", Elf},
+	{"a1 b2 c3 d4, d4 c3 b2 a1|Packet Capture|pcap|application/vnd.tcpdump.pcap", Pcap},
+	{"25 50 44 46 2d,@1-1024:25 50 44 46 2d|PDF Document|pdf|application/pdf", Pdf},
+	{"53 51 4c 69 74 65 20 66 6f 72 6d 61 74 20 33 00|SQLite Database|db|application/vnd.sqlite3", SqliteDatabase},
+	{"00 00 01 00|Computer Icon|ico|image/vnd.microsoft.icon", Ico},
+	{"47 49 46 38 37 61,47 49 46 38 39 61|GIF Image|gif|image/gif", Gif},
+	{"89 50 4E 47 0D 0A 1A 0A|Portable Network Graphics|png|image/png", Png},
+	{"CA FE BA BE,CA FE BA BF,FE ED FA CE,FE ED FA CF,CE FA ED FE,CF FA ED FE|Mach-O Binary||application/x-mach-binary", Macho},
+	{"CA FE BA BE|Java Class|class|application/java-vm", JavaClass},
+	{"52 49 46 46 ?? ?? ?? ?? 57 41 56 45|Waveform Audio File|wav|audio/wav", Wav},
+	{"52 49 46 46 ?? ?? ?? ?? 41 56 49 20|Audio Video Interleave|avi|video/x-msvideo", Avi},
+	{"FF FB|MP3 File|mp3|audio/mpeg", Mp3},
+	{"D0 CF 11 E0 A1 B1 1A E1|Windows Installer Package|msi|application/x-msi", Msi},
+	{"D0 CF 11 E0 A1 B1 1A E1|Office Document|doc,xls,ppt|application/x-ole-storage", Office},
+	{"64 65 78 0A 30 33 35 00|Dalvik Executable|dex|application/vnd.android.dex", Dalvik},
+	{"@257:75 73 74 61 72 00 30 30,@257:75 73 74 61 72 20 20 00|Tar Archive|tar|application/x-tar", Tar},
+	{"37 7A BC AF 27 1C|7-Zip|7z|application/x-7z-compressed", SevenZip},
+	{"1F 8B|Gzip|gz|application/gzip", Gzip},
+	{"43 57 53,46 57 53|Shockwave Flash|swf|application/x-shockwave-flash", Swf},
+	{"FF D8 FF DB,FF D8 FF E0 00 10 4A 46 49 46 00 01,FF D8 FF EE,FF D8 FF E1 ?? ?? 45 78 69 66 00 00|JPEG Image|jpg,jpeg|image/jpeg", Jpg},
+	{"50 4B 03 04,50 4B 05 06,50 4B 07 08|Zip Archive|zip|application/zip", Zip},
+	{"52 61 72 21 1A 07 00,52 61 72 21 1A 07 01 00|RAR Archive|rar|application/vnd.rar", Rar},
+	{"@0x8001;0x8801;0x9001:43 44 30 30 31,@0x8001;0x8801;0x9001:42 45 41 30 31|ISO Image|iso,img|application/x-iso9660-image", Iso},
+	{"4F 67 67 53|Ogg Vorbis Data|ogg|audio/ogg", Ogg},
+	{"4D 53 43 46|Windows Cabinet Archive|cab|application/vnd.ms-cab-compressed", Cab},
+	{"00 61 73 6d|WebAssembly|wasm|application/wasm", Wasm},
+	{"42 5A 68|Bzip2 Data|bz2|application/x-bzip2", Bzip2},
+	{"49 49 2A 00,4D 4D 00 2A|Tagged Image File Format|tiff|image/tiff", Tiff},
+	{"25 21 50 53|PostScript Document|ps|application/postscript", PostScript},
+	{"43 72 32 34|Chrome Extension|crx|application/x-chrome-extension", ChromeExtension},
+	{"78 01 73 0D 62 62 60|Apple Disk Image|dmg|application/x-apple-diskimage", Dmg},
+	{"7B 5C 72 74 66 31|RTF Document|rtf|application/rtf", Rtf},
+	{"38 42 50 53|PhotoShop Document|psd|image/vnd.adobe.photoshop", PhotoShop},
+	{"78 01,78 9C,78 DA|Zlib Data|zlib|application/zlib", Zlib},
+	{"FD 37 7A 58 5A 00|XZ Compressed Data|xz|application/x-xz", Xz},
+	{"28 B5 2F FD|Zstandard Compressed Data|zst|application/zstd", Zstd},
+	{"04 22 4D 18|LZ4 Compressed Data|lz4|application/x-lz4", Lz4},
+	{"4C 00 00 00 01 14 02 00 00 00 00 00 C0 00 00 00 00 00 00 46|Windows Shortcut|lnk|application/x-ms-shortcut", Lnk},
+	{"49 54 53 46|Compiled HTML Help|chm|application/vnd.ms-htmlhelp", Chm},
+	{"E4 52 5C 7B 8C D8 A7 4D AE B1 53 78 D0 29 96 D3|OneNote Document|one|application/onenote", OneNote},
+	{"63 6F 6E 65 63 74 69 78|Virtual Hard Disk|vhd|application/x-vhd", Vhd},
+	{"76 68 64 78 66 69 6C 65|Virtual Hard Disk v2|vhdx|application/x-vhdx", Vhdx},
+	{"4D 53 57 49 4D 00 00 00|Windows Imaging Format|wim|application/x-ms-wim", Wim},
+	{"30 37 30 37 30 31,30 37 30 37 30 32,30 37 30 37 30 37|CPIO Archive|cpio|application/x-cpio", Cpio},
+	{"ED AB EE DB|RPM Package|rpm|application/x-rpm", Rpm},
+	{"21 3C 61 72 63 68 3E 0A|Debian Package|deb|application/vnd.debian.binary-package", Deb},
+	{"21 3C 61 72 63 68 3E 0A|Ar Archive|a|application/x-archive", Ar},
+	{"72 65 67 66|Windows Registry Hive||application/x-ms-registry-hive", RegistryHive},
+	{"45 6C 66 46 69 6C 65 00|Windows Event Log|evtx|application/x-ms-evtx", Evtx},
+	{"0A 0D 0D 0A|Packet Capture Next Generation|pcapng|application/x-pcapng", PcapNg},
 }
 
 func init() {
@@ -126,9 +208,38 @@ func init() {
 		}
 		signatureEntries = append(signatureEntries, fi)
 	}
+
+	DefaultRegistry = NewRegistry()
 }
 
 func (s *signature) Matches(data []byte) bool {
+	_, ok := s.find(data)
+	return ok
+}
+
+// Returns the first offset the signature matches at
+func (s *signature) find(data []byte) (int, bool) {
+	if len(s.Locations) == 0 {
+		return 0, s.matchesAt(data, 0)
+	}
+
+	for _, l := range s.Locations {
+		for offset := l.Start; offset <= l.End && offset < len(data); offset++ {
+			if s.matchesAt(data, offset) {
+				return offset, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func (s *signature) matchesAt(data []byte, offset int) bool {
+	if offset < 0 || offset > len(data) {
+		return false
+	}
+	data = data[offset:]
+
 	for i := 0; i < len(s.Nibbles); i += 1 {
 		if i/2 >= len(data) {
 			return false
@@ -153,14 +264,35 @@ func (s *signature) Matches(data []byte) bool {
 	return true
 }
 
+// Parses a signature such as "25 50 44 46", with ?? or ? for wildcard bytes or nibbles.
+// A prefix gives where the signature starts when it is not at offset zero, as a list of
+// offsets or inclusive ranges separated by semicolons: "@257:75 73 74 61 72",
+// "@0x8001;0x8801;0x9001:43 44 30 30 31" or "@0-1024:25 50 44 46".
 func newSignature(s string) (*signature, error) {
 	s = removeAllWhitespace(s)
 
+	result := &signature{}
+
+	if strings.HasPrefix(s, "@") {
+		i := strings.IndexByte(s, ':')
+		if i < 0 {
+			return nil, errors.New("missing ':' after signature locations")
+		}
+
+		for _, field := range strings.Split(s[1:i], ";") {
+			l, err := parseLocation(field)
+			if err != nil {
+				return nil, err
+			}
+			result.Locations = append(result.Locations, l)
+		}
+		s = s[i+1:]
+	}
+
 	if len(s)%2 != 0 {
 		return nil, errors.New("odd-length input string")
 	}
 
-	result := &signature{}
 	for i := 0; i < len(s); i++ {
 		isWildcard := true
 		var value byte
@@ -183,13 +315,41 @@ func newSignature(s string) (*signature, error) {
 	return result, nil
 }
 
+func parseLocation(s string) (location, error) {
+	bounds := strings.SplitN(s, "-", 2)
+
+	start, err := strconv.ParseInt(bounds[0], 0, 32)
+	if err != nil {
+		return location{}, err
+	}
+	end := start
+	if len(bounds) == 2 {
+		end, err = strconv.ParseInt(bounds[1], 0, 32)
+		if err != nil {
+			return location{}, err
+		}
+	}
+
+	if start < 0 || end < start {
+		return location{}, errors.New("bad signature location " + s)
+	}
+	if end-start > maxSearchRange {
+		return location{}, errors.New("signature search range too large " + s)
+	}
+
+	return location{Start: int(start), End: int(end)}, nil
+}
+
 func newFileInfo(s string, id Id) (*signatureEntry, error) {
 	fi := &signatureEntry{}
 
-	fields := strings.SplitN(s, "|", 3)
+	fields := strings.SplitN(s, "|", 4)
 	if len(fields) < 3 {
 		return nil, errors.New("bad number of fields")
 	}
+	if len(fields) == 4 {
+		fi.MimeType = strings.TrimSpace(fields[3])
+	}
 
 	hexSignatures := strings.Split(fields[0], ",")
 	for _, s := range hexSignatures {
@@ -233,32 +393,148 @@ func removeAllWhitespace(s string) string {
 
 var signatureEntries []*signatureEntry
 
-func getFinalFileType(data []byte) FileType {
-	if len(data) == 0 {
-		return FileType{FileTypeId: Empty, Description: "Empty", Extensions: nil}
+// Confidence of the types that do not come from signatures
+const (
+	confidenceEmpty  = 1.0
+	confidenceBinary = 0.1
+	confidenceText   = 0.2
+	confidencePe     = 0.95
+	confidenceDos    = 0.6
+	confidenceOoxml  = 0.9
+	confidencePyc    = 0.9
+	// A footer with a valid checksum at the very end
+	confidenceVhdFooter = 0.9
+
+	// Types found from the entries of a zip
+	confidenceOoxmlPart        = 0.95
+	confidenceOdf              = 0.95
+	confidenceJar              = 0.9
+	confidenceApk              = 0.95
+	confidenceBrowserExtension = 0.9
+)
+
+// Signatures score by their number of fixed bytes, so that "MZ" counts for less than an
+// eight byte magic number
+func signatureConfidence(s *signature) float64 {
+	confidence := 0.5 + 0.05*float64(fixedBytes(s))
+	if confidence > 0.9 {
+		confidence = 0.9
 	}
 
-	for _, b := range data {
-		if b >= 0x80 || b == 0x00 {
-			return FileType{FileTypeId: Binary, Description: "Binary", Extensions: []string{"dat", "bin"}}
+	return roundConfidence(confidence)
+}
+
+// Keeps scores readable, without float noise like 0.7999999999999999
+func roundConfidence(confidence float64) float64 {
+	return math.Round(confidence*100) / 100
+}
+
+// Raises the confidence of types whose structure checks out and lowers it for those whose
+// structure does not, using the carving checks
+func validatedConfidence(id Id, confidence float64, data []byte) float64 {
+	f, ok := lengthFuncs[id]
+	if !ok {
+		// Types with structure checks are only found once their check passed
+		if _, ok := structureChecks[id]; ok {
+			return validConfidence(confidence)
 		}
+		return confidence
+	}
+
+	if _, ok := f(data); !ok {
+		return roundConfidence(confidence / 2)
+	}
+
+	return validConfidence(confidence)
+}
+
+func validConfidence(confidence float64) float64 {
+	confidence += 0.1
+	if confidence > 1 {
+		confidence = 1
 	}
 
-	return FileType{FileTypeId: Text, Description: "Text", Extensions: []string{"txt"}}
+	return roundConfidence(confidence)
+}
+
+func getFinalFileType(data []byte, probe textProbe) FileType {
+	if len(data) == 0 {
+		return withConfidence(emptyType, confidenceEmpty)
+	}
+
+	if !probe.isText {
+		return withConfidence(binaryType, confidenceBinary)
+	}
+
+	ft := withConfidence(plainTextType, confidenceText)
+	ft.Encoding = probe.encoding
+	return ft
 }
 
 func Get1(data []byte) FileType {
 	return Get(data)[0]
 }
 
-func newFile(id Id, description string, extensions ...string) FileType {
+func newFile(id Id, description string, mimeType string, extensions ...string) FileType {
 	var a []string
 	for _, extension := range extensions {
 		if len(extension) > 0 {
 			a = append(a, extension)
 		}
 	}
-	return FileType{FileTypeId: id, Description: description, Extensions: a}
+	return FileType{FileTypeId: id, Description: description, Extensions: a, MimeType: mimeType}
+}
+
+// Types found by functions rather than signatures
+var (
+	emptyType     = newFile(Empty, "Empty", "application/x-empty")
+	binaryType    = newFile(Binary, "Binary", "application/octet-stream", "dat", "bin")
+	plainTextType = newFile(Text, "Text", "text/plain", "txt")
+	dosType       = newFile(Dos, "DOS Executable", "application/x-dosexec", "exe")
+	peType        = newFile(Pe, "PE Executable", "application/vnd.microsoft.portable-executable", "exe")
+)
+
+func withConfidence(ft FileType, confidence float64) FileType {
+	ft.Confidence = confidence
+	return ft
+}
+
+func newFileWithConfidence(id Id, description string, mimeType string, confidence float64, extensions ...string) FileType {
+	ft := newFile(id, description, mimeType, extensions...)
+	ft.Confidence = confidence
+	return ft
+}
+
+// Types sharing a magic number with another type are matched only when their structure
+// agrees with them
+var structureChecks = map[Id]func(data []byte) bool{
+	Macho:     macho.IsMacho,
+	JavaClass: isJavaClassStructure,
+
+	// Formats whose magic numbers are short or shared, or which have a version or
+	// checksum right after them
+	Xz:           isXzStructure,
+	Zstd:         isZstdStructure,
+	Lz4:          isLz4Structure,
+	Lnk:          isLnkStructure,
+	Msi:          isMsiStructure,
+	Chm:          isChmStructure,
+	OneNote:      isOneNoteStructure,
+	Vhd:          isVhdStructure,
+	Vhdx:         isVhdxStructure,
+	Wim:          isWimStructure,
+	Cpio:         isCpioStructure,
+	Rpm:          isRpmStructure,
+	Ar:           isArStructure,
+	Deb:          isDebStructure,
+	RegistryHive: isRegistryHiveStructure,
+	Evtx:         isEvtxStructure,
+	PcapNg:       isPcapNgStructure,
+}
+
+func isJavaClassStructure(p []byte) bool {
+	major, ok := binary.Uint16Be(p, 6)
+	return ok && major >= 45
 }
 
 // Should return least specific to most specific in the array (Example: DOS, PE, Dll, etc)
@@ -266,18 +542,20 @@ type fileTypeFunc func(data []byte) FileTypes
 
 var fileTypeFuncs = []fileTypeFunc{
 	isPeFile,
-	isOfficeXml,
+	isZipContainer,
+	isVhdFooter,
+	isPyc,
 }
 
 func isPeFile(p []byte) FileTypes {
 	var result []FileType
 
 	if v, ok := binary.Uint16Be(p, 0); ok && v == 0x4d5a {
-		result = append(result, newFile(Dos, "DOS Executable", "exe"))
+		result = append(result, withConfidence(dosType, confidenceDos))
 
 		if peOffset, ok := binary.Int32Le(p, 0x3C); ok {
 			if v, ok := binary.Uint32Be(p, int(peOffset)); ok && v == 0x50450000 {
-				result = append(result, newFile(Pe, "PE Executable", "exe"))
+				result = append(result, withConfidence(peType, confidencePe))
 			}
 		}
 	}
@@ -285,26 +563,21 @@ func isPeFile(p []byte) FileTypes {
 	return result
 }
 
-func isOfficeXml(p []byte) FileTypes {
-	var result []FileType
+func (ft *FileType) Matches(id Id) bool {
+	return ft.FileTypeId == id
+}
 
-	isOfficeXml := false
-	if v, ok := binary.Uint32Be(p, 0); ok && v == 0x504B0304 {
-		s := string(p)
-		if strings.Contains(s, "[Content_Types].xml") && strings.Contains(s, "docProps/core.xml") {
-			isOfficeXml = true
+// Best returns the type with the highest confidence, or the first of them on a tie since
+// more specific types come first
+func (fts FileTypes) Best() FileType {
+	var best FileType
+	for i, ft := range fts {
+		if i == 0 || ft.Confidence > best.Confidence {
+			best = ft
 		}
 	}
 
-	if isOfficeXml {
-		result = append(result, newFile(OpenOfficeXml, "Open Office XML", "docx", "pptx", "xlsx"))
-	}
-
-	return result
-}
-
-func (ft *FileType) Matches(id Id) bool {
-	return ft.FileTypeId == id
+	return best
 }
 
 func (fts FileTypes) Matches(id Id) bool {
@@ -317,31 +590,52 @@ func (fts FileTypes) Matches(id Id) bool {
 	return false
 }
 
+// Get returns the types of data using the built-in signatures and those registered with
+// DefaultRegistry
 func Get(data []byte) FileTypes {
+	return DefaultRegistry.Get(data)
+}
+
+func (r *Registry) Get(data []byte) FileTypes {
+	r.mu.RLock()
+	entries := r.entries
+	r.mu.RUnlock()
+
 	var result []FileType
+	text := probeText(data)
 
-	// Match using functions first
-	for _, f := range fileTypeFuncs {
-		ft := f(data)
+	add := func(ft FileTypes) {
 		// Iterate in reverse to add the most specific type first in the result array
 		for i := len(ft) - 1; i >= 0; i-- {
 			result = append(result, ft[i])
 		}
 	}
 
+	// Match using functions first
+	for _, f := range fileTypeFuncs {
+		add(f(data))
+	}
+	add(isTextSubtype(data, text))
+
 	// Match with signature
-	for _, fi := range signatureEntries {
+	for _, fi := range entries {
 	InnerLoop:
 		for _, s := range fi.Signatures {
 			if s.Matches(data) {
-				result = append(result, fi.FileType)
+				if check, ok := structureChecks[fi.FileTypeId]; ok && !check(data) {
+					continue
+				}
+
+				ft := fi.FileType
+				ft.Confidence = validatedConfidence(ft.FileTypeId, signatureConfidence(s), data)
+				result = append(result, ft)
 				break InnerLoop
 			}
 		}
 	}
 
 	// Match against file initial type
-	result = append(result, getFinalFileType(data))
+	result = append(result, getFinalFileType(data, text))
 
 	return result
 }
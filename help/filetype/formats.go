@@ -0,0 +1,421 @@
+package filetype
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+	"github.com/gdcorp-infosec/threat-util/help/ole"
+)
+
+// The xz stream flags hold a check type and are followed by their CRC32
+func isXzStructure(p []byte) bool {
+	crc, ok := binary.Uint32Le(p, 8)
+	if !ok || p[6] != 0 {
+		return false
+	}
+
+	switch p[7] {
+	case 0x00, 0x01, 0x04, 0x0A:
+		return crc32.ChecksumIEEE(p[6:8]) == crc
+	}
+
+	return false
+}
+
+// The frame header descriptor has a reserved bit that must be clear
+func isZstdStructure(p []byte) bool {
+	descriptor, ok := binary.Uint8Le(p, 4)
+	return ok && descriptor&0x08 == 0
+}
+
+// The frame descriptor holds version 01, a clear reserved bit and a block size of 64K to
+// 4M
+func isLz4Structure(p []byte) bool {
+	flags, ok1 := binary.Uint8Le(p, 4)
+	blockDescriptor, ok2 := binary.Uint8Le(p, 5)
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	blockSize := blockDescriptor >> 4 & 0x07
+	return flags>>6 == 1 && flags&0x02 == 0 && blockDescriptor&0x8F == 0 && blockSize >= 4
+}
+
+// The show command of a shortcut is normal, maximized or minimized
+func isLnkStructure(p []byte) bool {
+	showCommand, ok := binary.Uint32Le(p, 0x3C)
+	return ok && (showCommand == 1 || showCommand == 3 || showCommand == 7)
+}
+
+// The installer CLSID 000C1084-0000-0000-C000-000000000046, as stored in a directory entry
+var msiClsid = []byte{0x84, 0x10, 0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, 0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}
+
+// MSI installers are compound files whose root storage has the installer CLSID. The root
+// is the first entry of the first directory sector, so the directory is not walked.
+func isMsiStructure(p []byte) bool {
+	sectorShift, ok1 := binary.Uint16Le(p, 0x1E)
+	firstDirSector, ok2 := binary.Uint32Le(p, 0x30)
+	if !ok1 || !ok2 || sectorShift < 7 || sectorShift > 16 {
+		return false
+	}
+
+	root := (uint64(firstDirSector) + 1) << sectorShift
+	if root+0x60 > uint64(len(p)) {
+		return false
+	}
+
+	return p[root+0x42] == ole.TypeRoot && bytes.Equal(p[root+0x50:root+0x60], msiClsid)
+}
+
+func isChmStructure(p []byte) bool {
+	version, ok1 := binary.Uint32Le(p, 4)
+	headerLength, ok2 := binary.Uint32Le(p, 8)
+	return ok1 && ok2 && (version == 2 || version == 3) && (headerLength == 0x58 || headerLength == 0x60)
+}
+
+// The header GUID is followed by the GUID of the file format
+var oneNoteFormatGuid = []byte{0x3F, 0xDD, 0x9A, 0x10, 0x1B, 0x91, 0xF5, 0x49, 0xA5, 0xD0, 0x17, 0x91, 0xED, 0xC8, 0xAE, 0xD8}
+
+func isOneNoteStructure(p []byte) bool {
+	return len(p) >= 32 && bytes.Equal(p[16:32], oneNoteFormatGuid)
+}
+
+const vhdFooterSize = 512
+
+// Checks the disk type and checksum of a VHD footer, which dynamic disks copy to the
+// start of the file
+func isVhdStructure(p []byte) bool {
+	if len(p) < vhdFooterSize {
+		return false
+	}
+
+	diskType, _ := binary.Uint32Be(p, 60)
+	checksum, _ := binary.Uint32Be(p, 64)
+	if diskType < 2 || diskType > 4 {
+		return false
+	}
+
+	var sum uint32
+	for i, b := range p[:vhdFooterSize] {
+		if i < 64 || i >= 68 {
+			sum += uint32(b)
+		}
+	}
+
+	return ^sum == checksum
+}
+
+// Fixed size VHDs only have the footer, at the end of the file
+func isVhdFooter(p []byte) FileTypes {
+	if len(p) <= vhdFooterSize {
+		return nil
+	}
+
+	footer := p[len(p)-vhdFooterSize:]
+	if !bytes.HasPrefix(footer, []byte("conectix")) || !isVhdStructure(footer) {
+		return nil
+	}
+
+	return FileTypes{withConfidence(vhdType, confidenceVhdFooter)}
+}
+
+var vhdType = newFile(Vhd, "Virtual Hard Disk", "application/x-vhd", "vhd")
+
+// The first of the two VHDX headers follows the 64K file identifier
+func isVhdxStructure(p []byte) bool {
+	return bytes.HasPrefix(p[min(len(p), 0x10000):], []byte("head"))
+}
+
+func isWimStructure(p []byte) bool {
+	headerSize, ok := binary.Uint32Le(p, 8)
+	return ok && headerSize == 0xD0
+}
+
+const cpioTrailer = "TRAILER!!!"
+
+// Reads the name and data size of a newc or odc CPIO header
+func cpioHeader(p []byte) (headerSize int, nameSize int, dataSize int, align int, ok bool) {
+	field := func(offset int, length int, base int) (int, bool) {
+		if offset+length > len(p) {
+			return 0, false
+		}
+		v, err := strconv.ParseUint(string(p[offset:offset+length]), base, 32)
+		return int(v), err == nil
+	}
+
+	switch {
+	case bytes.HasPrefix(p, []byte("070701")), bytes.HasPrefix(p, []byte("070702")):
+		// Every field is eight hex digits, padded to four bytes after the name and data
+		for offset := 6; offset < 110; offset += 8 {
+			if _, ok := field(offset, 8, 16); !ok {
+				return 0, 0, 0, 0, false
+			}
+		}
+		dataSize, _ = field(54, 8, 16)
+		nameSize, _ = field(94, 8, 16)
+		return 110, nameSize, dataSize, 4, true
+	case bytes.HasPrefix(p, []byte("070707")):
+		for _, f := range [][2]int{{6, 6}, {12, 6}, {18, 6}, {24, 6}, {30, 6}, {36, 6}, {42, 6}, {48, 11}, {59, 6}, {65, 11}} {
+			if _, ok := field(f[0], f[1], 8); !ok {
+				return 0, 0, 0, 0, false
+			}
+		}
+		nameSize, _ = field(59, 6, 8)
+		dataSize, _ = field(65, 11, 8)
+		return 76, nameSize, dataSize, 1, true
+	}
+
+	return 0, 0, 0, 0, false
+}
+
+func isCpioStructure(p []byte) bool {
+	_, _, _, _, ok := cpioHeader(p)
+	return ok
+}
+
+func alignUp(v int, align int) int {
+	return (v + align - 1) / align * align
+}
+
+// Walks the entries up to the trailer entry
+func cpioLength(p []byte) (int, bool) {
+	offset := 0
+	for {
+		headerSize, nameSize, dataSize, align, ok := cpioHeader(p[offset:])
+		if !ok {
+			return 0, offset > 0
+		}
+
+		nameEnd := offset + headerSize + nameSize
+		if nameEnd > len(p) {
+			return 0, true
+		}
+		name := bytes.TrimRight(p[offset+headerSize:nameEnd], "\x00")
+
+		offset = alignUp(alignUp(nameEnd, align)+dataSize, align)
+		if string(name) == cpioTrailer {
+			return offset, true
+		}
+		if offset >= len(p) {
+			return 0, true
+		}
+	}
+}
+
+// The lead is followed by the signature header
+func isRpmStructure(p []byte) bool {
+	major, ok := binary.Uint8Le(p, 4)
+	return ok && (major == 3 || major == 4) && bytes.HasPrefix(p[min(len(p), 96):], []byte{0x8E, 0xAD, 0xE8, 0x01})
+}
+
+const arHeaderSize = 60
+
+// The first member header ends with a backquote and a newline
+func isArStructure(p []byte) bool {
+	return len(p) >= 8+arHeaderSize && bytes.Equal(p[8+58:8+60], []byte("`\n"))
+}
+
+// Debian packages are ar archives starting with the debian-binary member
+func isDebStructure(p []byte) bool {
+	return isArStructure(p) && bytes.HasPrefix(p[8:], []byte("debian-binary"))
+}
+
+// Walks the members, each padded to an even size
+func arLength(p []byte) (int, bool) {
+	if !isArStructure(p) {
+		return 0, false
+	}
+
+	offset := 8
+	for offset+arHeaderSize <= len(p) && bytes.Equal(p[offset+58:offset+60], []byte("`\n")) {
+		size, err := strconv.Atoi(string(bytes.TrimSpace(p[offset+48 : offset+58])))
+		if err != nil || size < 0 {
+			break
+		}
+		offset = alignUp(offset+arHeaderSize+size, 2)
+	}
+
+	if offset > len(p) {
+		return 0, true
+	}
+
+	return offset, true
+}
+
+// Hives are version 1.3 to 1.6, with the first hive bin after the 4K base block
+func isRegistryHiveStructure(p []byte) bool {
+	major, ok1 := binary.Uint32Le(p, 20)
+	minor, ok2 := binary.Uint32Le(p, 24)
+	if !ok1 || !ok2 || major != 1 || minor < 3 || minor > 6 {
+		return false
+	}
+
+	return len(p) < 0x1004 || bytes.HasPrefix(p[0x1000:], []byte("hbin"))
+}
+
+func registryHiveLength(p []byte) (int, bool) {
+	size, ok := binary.Uint32Le(p, 40)
+	if !ok || size%0x1000 != 0 {
+		return 0, false
+	}
+
+	return 0x1000 + int(size), true
+}
+
+const (
+	evtxHeaderBlockSize = 0x1000
+	evtxChunkSize       = 0x10000
+)
+
+func isEvtxStructure(p []byte) bool {
+	headerSize, ok1 := binary.Uint32Le(p, 32)
+	major, ok2 := binary.Uint16Le(p, 38)
+	blockSize, ok3 := binary.Uint16Le(p, 40)
+	return ok1 && ok2 && ok3 && headerSize == 128 && major == 3 && blockSize == evtxHeaderBlockSize
+}
+
+func evtxLength(p []byte) (int, bool) {
+	chunks, ok := binary.Uint16Le(p, 42)
+	if !ok || !isEvtxStructure(p) {
+		return 0, false
+	}
+
+	return evtxHeaderBlockSize + int(chunks)*evtxChunkSize, true
+}
+
+// Returns the byte order of a section header block, from its byte order magic
+func pcapNgEndianness(p []byte) (binary.Endianness, bool) {
+	magic, ok := binary.Uint32Le(p, 8)
+	switch {
+	case !ok:
+		return binary.LittleEndian, false
+	case magic == 0x1A2B3C4D:
+		return binary.LittleEndian, true
+	case magic == 0x4D3C2B1A:
+		return binary.BigEndian, true
+	}
+
+	return binary.LittleEndian, false
+}
+
+// Each block repeats its length at its end
+func pcapNgBlockLength(p []byte, offset int, endianness binary.Endianness) (int, bool) {
+	length, ok1 := binary.GetUint64(endianness, p, offset+4, 4)
+	if !ok1 || length < 12 || length%4 != 0 || offset+int(length) > len(p) {
+		return 0, false
+	}
+
+	trailing, ok2 := binary.GetUint64(endianness, p, offset+int(length)-4, 4)
+	return int(length), ok2 && trailing == length
+}
+
+func isPcapNgStructure(p []byte) bool {
+	endianness, ok := pcapNgEndianness(p)
+	if !ok {
+		return false
+	}
+
+	length, ok := binary.GetUint64(endianness, p, 4, 4)
+	return ok && length >= 28 && length%4 == 0
+}
+
+// Walks the blocks, up to a new section or the first damaged block
+func pcapNgLength(p []byte) (int, bool) {
+	endianness, ok := pcapNgEndianness(p)
+	if !ok {
+		return 0, false
+	}
+
+	offset := 0
+	for offset < len(p) {
+		if offset > 0 && bytes.HasPrefix(p[offset:], []byte{0x0A, 0x0D, 0x0D, 0x0A}) {
+			break
+		}
+		length, ok := pcapNgBlockLength(p, offset, endianness)
+		if !ok {
+			break
+		}
+		offset += length
+	}
+
+	if offset == 0 {
+		return 0, true
+	}
+
+	return offset, true
+}
+
+// The magic numbers of each Python version are a range, increased as the bytecode changed
+var pycVersions = []struct {
+	First   uint16
+	Last    uint16
+	Version string
+}{
+	{62071, 62131, "2.5"},
+	{62151, 62161, "2.6"},
+	{62171, 62211, "2.7"},
+	{3000, 3131, "3.0"},
+	{3141, 3151, "3.1"},
+	{3160, 3180, "3.2"},
+	{3190, 3230, "3.3"},
+	{3250, 3310, "3.4"},
+	{3320, 3351, "3.5"},
+	{3360, 3379, "3.6"},
+	{3390, 3394, "3.7"},
+	{3400, 3413, "3.8"},
+	{3420, 3425, "3.9"},
+	{3430, 3439, "3.10"},
+	{3450, 3495, "3.11"},
+	{3500, 3531, "3.12"},
+	{3550, 3571, "3.13"},
+}
+
+// Python bytecode has a two byte magic number followed by CRLF, a header whose size
+// depends on the version, and a marshaled code object
+func isPyc(p []byte) FileTypes {
+	magic, ok := binary.Uint16Le(p, 0)
+	if !ok || !bytes.HasPrefix(p[2:], []byte("\r\n")) {
+		return nil
+	}
+
+	for _, v := range pycVersions {
+		if magic < v.First || magic > v.Last {
+			continue
+		}
+
+		headerSize := 8
+		switch {
+		case magic >= 3390 && magic < 62000:
+			headerSize = 16
+			if flags, ok := binary.Uint32Le(p, 4); !ok || flags > 3 {
+				return nil
+			}
+		case magic >= 3190 && magic < 62000:
+			headerSize = 12
+		}
+
+		// The code object type, with or without the reference flag
+		if code, ok := binary.Uint8Le(p, headerSize); !ok || code&0x7F != 'c' {
+			return nil
+		}
+
+		ft := withConfidence(pycType, confidencePyc)
+		ft.Description = fmt.Sprintf("%v (Python %v)", pycType.Description, v.Version)
+		return FileTypes{ft}
+	}
+
+	return nil
+}
+
+var pycType = newFile(Pyc, "Python Bytecode", "application/x-python-code", "pyc")
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
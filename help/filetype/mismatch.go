@@ -0,0 +1,328 @@
+package filetype
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+// Types below this confidence, such as keyword heuristics and two byte signatures, are
+// too weak to make a file a polyglot
+const minPolyglotConfidence = 0.75
+
+// Extensions that run code when opened, which double extensions hide behind a harmless
+// looking one
+var executableExtensions = map[string]bool{
+	"exe": true, "scr": true, "com": true, "pif": true, "cpl": true, "dll": true,
+	"bat": true, "cmd": true, "ps1": true, "vbs": true, "vbe": true, "js": true,
+	"jse": true, "wsf": true, "wsh": true, "hta": true, "lnk": true, "msi": true,
+	"jar": true,
+}
+
+// Other names for the extensions in the type table
+var extensionAliases = map[string]string{
+	"dll":   "exe",
+	"sys":   "exe",
+	"scr":   "exe",
+	"cpl":   "exe",
+	"ocx":   "exe",
+	"drv":   "exe",
+	"efi":   "exe",
+	"com":   "exe",
+	"jpeg":  "jpg",
+	"jpe":   "jpg",
+	"tif":   "tiff",
+	"htm":   "html",
+	"xhtml": "html",
+	"cmd":   "bat",
+	"dotx":  "docx",
+	"dotm":  "docm",
+	"xltx":  "xlsx",
+	"xltm":  "xlsm",
+	"potx":  "pptx",
+	"potm":  "pptm",
+	"tgz":   "gz",
+	"jse":   "js",
+	"vbe":   "vbs",
+}
+
+// Types that refine another, such as a PE refining a DOS executable, count as one format
+// when looking for polyglots
+var typeFamilies = map[Id]Id{
+	Pe:               Dos,
+	OpenOfficeXml:    Zip,
+	Docx:             Zip,
+	Docm:             Zip,
+	Xlsx:             Zip,
+	Xlsm:             Zip,
+	Pptx:             Zip,
+	Pptm:             Zip,
+	OpenDocument:     Zip,
+	Jar:              Zip,
+	Apk:              Zip,
+	BrowserExtension: Zip,
+	ChromeExtension:  Zip,
+	Hta:              Html,
+	Svg:              Xml,
+	Msi:              Office,
+	Deb:              Ar,
+}
+
+// Characters that reorder the text after them, used to make "exe.pdf" read as "fdp.exe"
+var bidiControls = []rune{'\u202A', '\u202B', '\u202C', '\u202D', rightToLeftOverride, '\u2066', '\u2067', '\u2068', '\u2069'}
+
+const rightToLeftOverride = '\u202E'
+
+// PolyglotType is one of the formats a polyglot is valid as
+type PolyglotType struct {
+	FileType
+	// Where the signature or structure of the type was found
+	Offset int
+}
+
+// Mismatch reports how a file's name disagrees with its contents
+type Mismatch struct {
+	FileName string `json:",omitempty"`
+	// Last extension of the name, lower case
+	Extension string `json:",omitempty"`
+	// Set when the extension is not one of the detected types'
+	ExtensionMismatch  bool
+	ExpectedExtensions []string `json:",omitempty"`
+	// Set for names like "invoice.pdf.exe"
+	DoubleExtension bool
+	// Set when the name holds bidirectional control characters, with the name as it
+	// is displayed
+	RightToLeftOverride bool
+	DisplayedName       string `json:",omitempty"`
+	// Set when the data is valid as several formats at once
+	Polyglot      bool
+	PolyglotTypes []PolyglotType `json:",omitempty"`
+}
+
+// Suspicious reports whether any check found something
+func (m *Mismatch) Suspicious() bool {
+	return m.ExtensionMismatch || m.DoubleExtension || m.RightToLeftOverride || m.Polyglot
+}
+
+// CheckMismatch compares the name of a file with the types of its data using
+// DefaultRegistry. An empty name only checks for polyglots.
+func CheckMismatch(fileName string, data []byte) *Mismatch {
+	return DefaultRegistry.CheckMismatch(fileName, data)
+}
+
+func (r *Registry) CheckMismatch(fileName string, data []byte) *Mismatch {
+	m := &Mismatch{FileName: fileName}
+	fileTypes := r.Get(data)
+
+	base := fileName
+	if i := strings.LastIndexAny(base, `/\`); i >= 0 {
+		base = base[i+1:]
+	}
+
+	if containsBidiControl(base) {
+		m.RightToLeftOverride = true
+		m.DisplayedName = displayedName(base)
+	}
+
+	extensions := nameExtensions(base)
+	if len(extensions) > 0 {
+		m.Extension = extensions[len(extensions)-1]
+		r.checkExtension(m, fileTypes)
+	}
+	if len(extensions) > 1 && executableExtensions[m.Extension] && !executableExtensions[extensions[len(extensions)-2]] &&
+		r.isKnownExtension(extensions[len(extensions)-2]) {
+		m.DoubleExtension = true
+	}
+
+	m.PolyglotTypes = r.polyglotTypes(data, fileTypes)
+	m.Polyglot = len(m.PolyglotTypes) > 1
+
+	return m
+}
+
+// Returns the extensions of a name, lower case and without surrounding spaces, which
+// pad names like "invoice.pdf      .exe"
+func nameExtensions(name string) []string {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return nil
+	}
+
+	var extensions []string
+	for _, part := range parts[1:] {
+		if extension := strings.ToLower(strings.TrimSpace(part)); extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+
+	return extensions
+}
+
+func canonicalExtension(extension string) string {
+	if alias, ok := extensionAliases[extension]; ok {
+		return alias
+	}
+
+	return extension
+}
+
+func (r *Registry) isKnownExtension(extension string) bool {
+	_, ok := r.IdByExtension(extension)
+	return ok
+}
+
+// Reports whether an extension runs code or belongs to a format other than text
+func (r *Registry) isBinaryExtension(extension string) bool {
+	if executableExtensions[extension] {
+		return true
+	}
+
+	id, ok := r.IdByExtension(extension)
+	return ok && id != Text && !isTextSubtypeId(id)
+}
+
+// Only types other than Text, Binary and Empty can contradict an extension, and only if
+// they have extensions of their own. Types without one, such as ELF shared objects named
+// "libc.so.6", can have any name. Text subtypes come from heuristics that also match
+// Markdown, PHP or logs, so they only contradict executable and binary format extensions.
+func (r *Registry) checkExtension(m *Mismatch, fileTypes FileTypes) {
+	expected := map[string]bool{}
+	specific := false
+	for _, ft := range fileTypes {
+		if isTextSubtypeId(ft.FileTypeId) && !r.isBinaryExtension(m.Extension) {
+			continue
+		}
+		hasExtension := false
+		for _, e := range ft.Extensions {
+			if e != "" {
+				expected[e] = true
+				hasExtension = true
+			}
+		}
+		if hasExtension && ft.FileTypeId != Text && ft.FileTypeId != Binary && ft.FileTypeId != Empty {
+			specific = true
+		}
+	}
+
+	if !specific || expected[canonicalExtension(m.Extension)] || expected[m.Extension] {
+		return
+	}
+
+	m.ExtensionMismatch = true
+	for e := range expected {
+		m.ExpectedExtensions = append(m.ExpectedExtensions, e)
+	}
+	sort.Strings(m.ExpectedExtensions)
+}
+
+func containsBidiControl(name string) bool {
+	return strings.IndexFunc(name, isBidiControl) >= 0
+}
+
+// Approximates how a name is displayed by reversing the text after a right-to-left
+// override, and dropping the control characters
+func displayedName(name string) string {
+	var sb strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == rightToLeftOverride {
+			var rest []rune
+			for _, r := range runes[i+1:] {
+				if !isBidiControl(r) {
+					rest = append(rest, r)
+				}
+			}
+			for j := len(rest) - 1; j >= 0; j-- {
+				sb.WriteRune(rest[j])
+			}
+			break
+		}
+		if !isBidiControl(r) {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
+
+func isBidiControl(r rune) bool {
+	for _, c := range bidiControls {
+		if r == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+func typeFamily(id Id) Id {
+	if family, ok := typeFamilies[id]; ok {
+		return family
+	}
+
+	return id
+}
+
+// Returns a type per format the data is confidently valid as. Besides the types found
+// by signatures at their own positions, zips are found from their central directory at
+// the end, as in GIFAR and PDF+ZIP files, and HTAs from the tag mshta looks for anywhere.
+func (r *Registry) polyglotTypes(data []byte, fileTypes FileTypes) []PolyglotType {
+	var result []PolyglotType
+	families := map[Id]bool{}
+	add := func(ft FileType, offset int) {
+		family := typeFamily(ft.FileTypeId)
+		if !families[family] {
+			families[family] = true
+			result = append(result, PolyglotType{FileType: ft, Offset: offset})
+		}
+	}
+
+	for _, ft := range fileTypes {
+		if ft.Confidence >= minPolyglotConfidence && ft.FileTypeId != Empty {
+			add(ft, r.signatureOffset(ft.FileTypeId, data))
+		}
+	}
+
+	if !families[Zip] {
+		if entries, ok := readZipCentralDirectory(data); ok {
+			offset := entries[0].Offset
+			for _, e := range entries {
+				if e.Offset < offset {
+					offset = e.Offset
+				}
+			}
+			if v, _ := binary.Uint32Le(data, offset); offset > 0 && v == zipLocalHeader {
+				add(withConfidence(r.lookupType(Zip), minPolyglotConfidence), offset)
+			}
+		}
+	}
+
+	if !families[Html] {
+		if loc := htaRegex.FindIndex(data); loc != nil {
+			add(htaType.fileType(minPolyglotConfidence, "HTA:APPLICATION tag"), loc[0])
+		}
+	}
+
+	return result
+}
+
+// Returns where a signature of the type matches, or zero for types found otherwise
+func (r *Registry) signatureOffset(id Id, data []byte) int {
+	r.mu.RLock()
+	entries := r.entries
+	r.mu.RUnlock()
+
+	for _, e := range entries {
+		if e.FileTypeId != id {
+			continue
+		}
+		for _, s := range e.Signatures {
+			if offset, ok := s.find(data); ok {
+				return offset
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,255 @@
+package filetype
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownName = errors.New("unknown file type name")
+
+// Stable names of the built-in types, used when Ids are marshaled so that stored results
+// keep their meaning when constants are added. Names must never change.
+var idNames = map[Id]string{
+	None:             "none",
+	Empty:            "empty",
+	Binary:           "binary",
+	Text:             "text",
+	Dos:              "dos",
+	Pe:               "pe",
+	Elf:              "elf",
+	Pcap:             "pcap",
+	SqliteDatabase:   "sqlite",
+	Ico:              "ico",
+	Jpg:              "jpg",
+	Gif:              "gif",
+	Bzip2:            "bzip2",
+	Zip:              "zip",
+	Rar:              "rar",
+	Png:              "png",
+	JavaClass:        "javaclass",
+	Swf:              "swf",
+	Macho:            "macho",
+	Ogg:              "ogg",
+	Pdf:              "pdf",
+	PostScript:       "postscript",
+	PhotoShop:        "psd",
+	Wav:              "wav",
+	Dmg:              "dmg",
+	Avi:              "avi",
+	Mp3:              "mp3",
+	Iso:              "iso",
+	Office:           "ole",
+	Dalvik:           "dex",
+	ChromeExtension:  "crx",
+	Tar:              "tar",
+	SevenZip:         "7z",
+	Gzip:             "gzip",
+	Zlib:             "zlib",
+	Cab:              "cab",
+	Wasm:             "wasm",
+	Rtf:              "rtf",
+	Tiff:             "tiff",
+	OpenOfficeXml:    "ooxml",
+	Docx:             "docx",
+	Docm:             "docm",
+	Xlsx:             "xlsx",
+	Xlsm:             "xlsm",
+	Pptx:             "pptx",
+	Pptm:             "pptm",
+	OpenDocument:     "odf",
+	Jar:              "jar",
+	Apk:              "apk",
+	BrowserExtension: "browserextension",
+	ShellScript:      "shellscript",
+	Html:             "html",
+	Xml:              "xml",
+	Svg:              "svg",
+	Json:             "json",
+	PowerShell:       "powershell",
+	Batch:            "batch",
+	JavaScript:       "javascript",
+	VbScript:         "vbscript",
+	Hta:              "hta",
+	Python:           "python",
+	Xz:               "xz",
+	Zstd:             "zstd",
+	Lz4:              "lz4",
+	Lnk:              "lnk",
+	Msi:              "msi",
+	Chm:              "chm",
+	OneNote:          "onenote",
+	Vhd:              "vhd",
+	Vhdx:             "vhdx",
+	Wim:              "wim",
+	Cpio:             "cpio",
+	Rpm:              "rpm",
+	Ar:               "ar",
+	Deb:              "deb",
+	RegistryHive:     "regf",
+	Evtx:             "evtx",
+	PcapNg:           "pcapng",
+	Pyc:              "pyc",
+}
+
+var nameIds = map[string]Id{}
+
+func init() {
+	for id, name := range idNames {
+		nameIds[name] = id
+	}
+}
+
+// String returns the stable name of a built-in type, or the number of any other
+func (id Id) String() string {
+	if name, ok := idNames[id]; ok {
+		return name
+	}
+
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func (id Id) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText accepts names and, for results stored before Ids had names, numbers
+func (id *Id) UnmarshalText(text []byte) error {
+	if v, ok := nameIds[strings.ToLower(string(text))]; ok {
+		*id = v
+		return nil
+	}
+
+	v, err := strconv.ParseUint(string(text), 10, 32)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrUnknownName, text)
+	}
+
+	*id = Id(v)
+	return nil
+}
+
+// UnmarshalJSON accepts the bare numbers Ids used to be marshaled as, besides names
+func (id *Id) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		name, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		return id.UnmarshalText([]byte(name))
+	}
+
+	return id.UnmarshalText(data)
+}
+
+// Types found by functions, in the order lookups prefer them. More specific types come
+// before those they refine.
+func functionTypes() []FileType {
+	result := []FileType{peType, dosType}
+
+	var parts []zipType
+	for _, t := range ooxmlContentTypes {
+		parts = append(parts, t)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Id < parts[j].Id })
+	for _, t := range parts {
+		result = append(result, newFile(t.Id, t.Description, t.MimeType, t.Extension))
+	}
+
+	var odfExtensionList []string
+	for _, extension := range odfExtensions {
+		odfExtensionList = append(odfExtensionList, extension)
+	}
+	sort.Strings(odfExtensionList)
+	result = append(result, newFile(OpenDocument, "OpenDocument", "", odfExtensionList...))
+
+	result = append(result, apkType, jarType, ooxmlType, browserExtensionType)
+	for _, t := range textTypes {
+		result = append(result, newFile(t.Id, t.Description, t.MimeType, t.Extension))
+	}
+
+	return append(result, pycType, plainTextType, binaryType, emptyType)
+}
+
+// Returns the signature types of the registry followed by the types found by functions
+func (r *Registry) knownTypes() []FileType {
+	r.mu.RLock()
+	entries := r.entries
+	r.mu.RUnlock()
+
+	result := make([]FileType, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.FileType)
+	}
+
+	return append(result, functionTypes()...)
+}
+
+// Returns the description, extensions and MIME type of a type
+func (r *Registry) lookupType(id Id) FileType {
+	for _, ft := range r.knownTypes() {
+		if ft.FileTypeId == id {
+			return ft
+		}
+	}
+
+	return FileType{FileTypeId: id}
+}
+
+// IdByExtension returns the type files with an extension usually have, such as Pe for
+// "exe" or "dll". The extension may start with a dot.
+func (r *Registry) IdByExtension(extension string) (Id, bool) {
+	extension = canonicalExtension(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), ".")))
+	if extension == "" {
+		return None, false
+	}
+
+	for _, ft := range r.knownTypes() {
+		for _, e := range ft.Extensions {
+			// Types without extensions have an empty one
+			if e != "" && e == extension {
+				return ft.FileTypeId, true
+			}
+		}
+	}
+
+	return None, false
+}
+
+// IdByMimeType returns the type of a MIME type, ignoring parameters such as the charset
+func (r *Registry) IdByMimeType(mimeType string) (Id, bool) {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if mimeType == "" {
+		return None, false
+	}
+
+	if strings.HasPrefix(mimeType, odfMimePrefix) {
+		return OpenDocument, true
+	}
+
+	for _, ft := range r.knownTypes() {
+		if strings.ToLower(ft.MimeType) == mimeType {
+			return ft.FileTypeId, true
+		}
+	}
+
+	return None, false
+}
+
+// IdByExtension looks up an extension in DefaultRegistry
+func IdByExtension(extension string) (Id, bool) {
+	return DefaultRegistry.IdByExtension(extension)
+}
+
+// IdByMimeType looks up a MIME type in DefaultRegistry
+func IdByMimeType(mimeType string) (Id, bool) {
+	return DefaultRegistry.IdByMimeType(mimeType)
+}
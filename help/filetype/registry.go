@@ -0,0 +1,197 @@
+package filetype
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// User-defined types must use IDs from this value up, leaving lower values to built-ins
+const FirstUserId Id = 1000
+
+var (
+	ErrReservedId  = errors.New("file type ID is reserved for built-in types")
+	ErrNoSignature = errors.New("file type has no signatures")
+)
+
+// Definition describes a file type loaded at runtime. Signatures use the syntax of the
+// built-in table, such as "4D 5A ?? 00" or "@257:75 73 74 61 72".
+type Definition struct {
+	Id          Id
+	Description string
+	Extensions  []string
+	MimeType    string
+	Signatures  []string
+}
+
+// ConflictError reports a definition whose ID or signature is already registered
+type ConflictError struct {
+	Id         Id
+	ExistingId Id
+	// Set when a signature conflicts, empty when the ID does
+	Signature string `json:",omitempty"`
+}
+
+func (e *ConflictError) Error() string {
+	if e.Signature == "" {
+		return fmt.Sprintf("file type ID %v is already registered", e.Id)
+	}
+
+	return fmt.Sprintf("signature %v of file type %v is already registered to file type %v", e.Signature, e.Id, e.ExistingId)
+}
+
+// ConflictErrors collects the conflicts of a set of definitions
+type ConflictErrors []*ConflictError
+
+func (e ConflictErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Registry combines the built-in signatures with user-defined ones
+type Registry struct {
+	mu      sync.RWMutex
+	entries []*signatureEntry
+	// IDs of user-defined types
+	ids     map[Id]bool
+	matcher *carveMatcher
+}
+
+// DefaultRegistry is used by Get and Carve
+var DefaultRegistry *Registry
+
+// NewRegistry returns a registry holding the built-in signatures
+func NewRegistry() *Registry {
+	r := &Registry{ids: map[Id]bool{}}
+	r.entries = append(r.entries, signatureEntries...)
+	r.matcher = newCarveMatcher(r.entries)
+
+	return r
+}
+
+func (s *signature) equal(other *signature) bool {
+	if len(s.Nibbles) != len(other.Nibbles) || len(s.Locations) != len(other.Locations) {
+		return false
+	}
+
+	for i := range s.Nibbles {
+		if s.WildcardNibbles[i] != other.WildcardNibbles[i] || (!s.WildcardNibbles[i] && s.Nibbles[i] != other.Nibbles[i]) {
+			return false
+		}
+	}
+
+	for i := range s.Locations {
+		if s.Locations[i] != other.Locations[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newDefinitionEntry(d Definition) (*signatureEntry, error) {
+	if len(d.Signatures) == 0 {
+		return nil, ErrNoSignature
+	}
+
+	e := &signatureEntry{FileType: newFile(d.Id, strings.TrimSpace(d.Description), strings.TrimSpace(d.MimeType), d.Extensions...)}
+	for _, s := range d.Signatures {
+		signature, err := newSignature(s)
+		if err != nil {
+			return nil, fmt.Errorf("file type %v: %v", d.Id, err)
+		}
+		e.Signatures = append(e.Signatures, signature)
+	}
+
+	return e, nil
+}
+
+// Register adds definitions to the registry. Either all of them are added or, when any
+// is invalid or conflicts with a registered type or another definition, none are.
+// Conflicts are returned as ConflictErrors.
+func (r *Registry) Register(definitions ...Definition) error {
+	var added []*signatureEntry
+	for _, d := range definitions {
+		if d.Id < FirstUserId {
+			return fmt.Errorf("file type %v: %w", d.Id, ErrReservedId)
+		}
+
+		e, err := newDefinitionEntry(d)
+		if err != nil {
+			return err
+		}
+		added = append(added, e)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var conflicts ConflictErrors
+	seen := map[Id]bool{}
+	for i, e := range added {
+		if r.ids[e.FileTypeId] || seen[e.FileTypeId] {
+			conflicts = append(conflicts, &ConflictError{Id: e.FileTypeId, ExistingId: e.FileTypeId})
+		}
+		seen[e.FileTypeId] = true
+
+		// Compare with the registered types and the definitions before this one
+		existing := append(r.entries[:len(r.entries):len(r.entries)], added[:i]...)
+		for j, s := range e.Signatures {
+		ExistingLoop:
+			for _, other := range existing {
+				for _, otherSignature := range other.Signatures {
+					if s.equal(otherSignature) {
+						conflicts = append(conflicts, &ConflictError{
+							Id:         e.FileTypeId,
+							ExistingId: other.FileTypeId,
+							Signature:  definitions[i].Signatures[j],
+						})
+						break ExistingLoop
+					}
+				}
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return conflicts
+	}
+
+	// Replace rather than append to the slice, which Get may be reading
+	entries := make([]*signatureEntry, 0, len(r.entries)+len(added))
+	entries = append(entries, r.entries...)
+	entries = append(entries, added...)
+	r.entries = entries
+	for _, e := range added {
+		r.ids[e.FileTypeId] = true
+	}
+	r.matcher = newCarveMatcher(r.entries)
+
+	return nil
+}
+
+// LoadDefinitions registers the definitions in a JSON array
+func (r *Registry) LoadDefinitions(data []byte) error {
+	var definitions []Definition
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return err
+	}
+
+	return r.Register(definitions...)
+}
+
+// Register adds definitions to DefaultRegistry
+func Register(definitions ...Definition) error {
+	return DefaultRegistry.Register(definitions...)
+}
+
+// LoadDefinitions registers the definitions in a JSON array with DefaultRegistry
+func LoadDefinitions(data []byte) error {
+	return DefaultRegistry.LoadDefinitions(data)
+}
@@ -0,0 +1,307 @@
+package filetype
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Upper bounds on the text the heuristics look at
+const (
+	maxTextScan = 64 * 1024
+	maxJsonSize = 1024 * 1024
+)
+
+// Confidence of the text heuristics. Keyword matches add confidenceKeyword each, up to
+// confidenceMaxKeywords.
+const (
+	confidenceShebang     = 0.9
+	confidenceMarkup      = 0.8
+	confidenceJson        = 0.8
+	confidenceKeyword     = 0.1
+	confidenceMaxKeywords = 0.7
+
+	// Weight of keywords needed before a language is reported
+	minKeywordWeight = 2
+)
+
+type textType struct {
+	Id          Id
+	Description string
+	MimeType    string
+	Extension   string
+}
+
+var (
+	shellScriptType = textType{ShellScript, "Shell Script", "text/x-shellscript", "sh"}
+	htmlType        = textType{Html, "HTML Document", "text/html", "html"}
+	xmlType         = textType{Xml, "XML Document", "text/xml", "xml"}
+	svgType         = textType{Svg, "SVG Image", "image/svg+xml", "svg"}
+	jsonType        = textType{Json, "JSON Data", "application/json", "json"}
+	powerShellType  = textType{PowerShell, "PowerShell Script", "text/x-powershell", "ps1"}
+	batchType       = textType{Batch, "Windows Batch File", "text/x-msdos-batch", "bat"}
+	javaScriptType  = textType{JavaScript, "JavaScript", "text/javascript", "js"}
+	vbScriptType    = textType{VbScript, "VBScript", "text/vbscript", "vbs"}
+	htaType         = textType{Hta, "HTML Application", "application/hta", "hta"}
+	pythonType      = textType{Python, "Python Script", "text/x-python", "py"}
+)
+
+var textTypes = []textType{shellScriptType, htmlType, xmlType, svgType, jsonType, powerShellType, batchType, javaScriptType, vbScriptType, htaType, pythonType}
+
+// Reports whether id is one of the types found in text by heuristics
+func isTextSubtypeId(id Id) bool {
+	for _, t := range textTypes {
+		if t.Id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t textType) fileType(confidence float64, heuristic string) FileType {
+	ft := newFileWithConfidence(t.Id, t.Description, t.MimeType, roundConfidence(confidence), t.Extension)
+	ft.Heuristic = heuristic
+	return ft
+}
+
+// Sets the encoding of the text the types were found in
+func withEncoding(result FileTypes, encoding string) FileTypes {
+	for i := range result {
+		result[i].Encoding = encoding
+	}
+
+	return result
+}
+
+// Interpreters named by a shebang, by their name without a version suffix
+var shebangInterpreters = map[string]textType{
+	"sh":      shellScriptType,
+	"bash":    shellScriptType,
+	"dash":    shellScriptType,
+	"zsh":     shellScriptType,
+	"ksh":     shellScriptType,
+	"csh":     shellScriptType,
+	"tcsh":    shellScriptType,
+	"ash":     shellScriptType,
+	"busybox": shellScriptType,
+	"python":  pythonType,
+	"node":    javaScriptType,
+	"nodejs":  javaScriptType,
+	"pwsh":    powerShellType,
+}
+
+var interpreterVersionRegex = regexp.MustCompile(`[0-9.]+$`)
+
+type keyword struct {
+	Name   string
+	Regex  *regexp.Regexp
+	Weight int
+}
+
+type keywordLanguage struct {
+	Type     textType
+	Keywords []keyword
+}
+
+// Languages told apart by the keywords they use. Keywords that give a language away on
+// their own weigh minKeywordWeight.
+var keywordLanguages = []keywordLanguage{
+	{powerShellType, []keyword{
+		{"cmdlet", regexp.MustCompile(`(?i)\b(get|set|new|invoke|start|stop|write|remove|add|out|convertto|convertfrom|select|where|foreach|test|import)-[a-z]{3,}\b`), 1},
+		{"Invoke-Expression", regexp.MustCompile(`(?i)\b(invoke-expression|iex)\b`), 2},
+		{".NET type", regexp.MustCompile(`(?i)\[(system\.)?(convert|text\.encoding|io\.[a-z]+|net\.webclient|reflection\.assembly)\]::`), 2},
+		{"launch option", regexp.MustCompile(`(?i)\s-(encodedcommand|enc|noprofile|nop|executionpolicy|ep|windowstyle|w)\s+[a-z0-9]`), 1},
+		{"automatic variable", regexp.MustCompile(`(?i)\$(env:|null\b|true\b|false\b|psscriptroot\b|_\.)`), 1},
+		{"param block", regexp.MustCompile(`(?i)\bparam\s*\(`), 1},
+	}},
+	{batchType, []keyword{
+		{"@echo off", regexp.MustCompile(`(?im)^\s*@echo\s+off\b`), 2},
+		{"command", regexp.MustCompile(`(?im)^\s*(set|if|goto|call|rem|start|setlocal|endlocal|exit\s+/b)\b`), 1},
+		{"label", regexp.MustCompile(`(?m)^\s*:[a-zA-Z_]\w*\s*$`), 1},
+		{"variable", regexp.MustCompile(`%~[a-z]*[0-9]|%[a-zA-Z_]\w*%|!\w+!`), 1},
+		{"comment", regexp.MustCompile(`(?im)^\s*(::|rem\s)`), 1},
+	}},
+	{javaScriptType, []keyword{
+		{"function", regexp.MustCompile(`\bfunction\s*[\w$]*\s*\([^)]*\)\s*\{`), 1},
+		{"declaration", regexp.MustCompile(`\b(var|let|const)\s+[\w$]+\s*=`), 1},
+		{"ActiveXObject", regexp.MustCompile(`\bnew\s+ActiveXObject\s*\(`), 2},
+		{"eval", regexp.MustCompile(`\beval\s*\(`), 1},
+		{"String.fromCharCode", regexp.MustCompile(`\bString\.fromCharCode\s*\(`), 2},
+		{"DOM", regexp.MustCompile(`\b(document|window)\.[a-zA-Z]+`), 1},
+		{"arrow function", regexp.MustCompile(`\)\s*=>\s*[{(\w]`), 1},
+	}},
+	{vbScriptType, []keyword{
+		{"Dim", regexp.MustCompile(`(?im)^\s*dim\s+\w+`), 1},
+		{"CreateObject", regexp.MustCompile(`(?i)\bcreateobject\s*\(`), 1},
+		{"End block", regexp.MustCompile(`(?im)^\s*end\s+(sub|function|if|with|select)\b`), 2},
+		{"On Error Resume Next", regexp.MustCompile(`(?i)\bon\s+error\s+resume\s+next\b`), 2},
+		{"Set", regexp.MustCompile(`(?im)^\s*set\s+\w+\s*=\s*\w+`), 1},
+		{"string concatenation", regexp.MustCompile(`(?i)(\bchr[wb]?\(\d+\)|")\s*&\s*(\bchr[wb]?\(|")`), 1},
+	}},
+	{pythonType, []keyword{
+		{"import", regexp.MustCompile(`(?m)^\s*(import\s+[\w.]+(\s+as\s+\w+)?\s*$|from\s+[\w.]+\s+import\s)`), 1},
+		{"def", regexp.MustCompile(`(?m)^\s*def\s+\w+\s*\(.*\)\s*(->.*)?:\s*$`), 1},
+		{"class", regexp.MustCompile(`(?m)^\s*class\s+\w+(\(.*\))?\s*:\s*$`), 1},
+		{"__main__", regexp.MustCompile(`\bif\s+__name__\s*==\s*['"]__main__['"]\s*:`), 2},
+		{"block", regexp.MustCompile(`(?m)^\s*(elif\s.*|else|try|except(\s.*)?|finally)\s*:\s*$`), 1},
+	}},
+}
+
+var (
+	htmlRegex = regexp.MustCompile(`(?i)<(!doctype\s+html|html|head|body|script|iframe)\b`)
+	htaRegex  = regexp.MustCompile(`(?i)<hta:application\b`)
+	svgRegex  = regexp.MustCompile(`(?i)<svg\b`)
+)
+
+// Classifies text with heuristics, returning the types least specific first. The
+// heuristic that fired is in each type's Heuristic. Text in other encodings than UTF-8
+// is decoded first.
+func isTextSubtype(data []byte, probe textProbe) FileTypes {
+	if len(data) == 0 || !probe.isText {
+		return nil
+	}
+
+	return withEncoding(textSubtypes(data, probe), probe.encoding)
+}
+
+func textSubtypes(data []byte, probe textProbe) FileTypes {
+	text := probe.text
+	if len(text) > maxTextScan {
+		text = text[:maxTextScan]
+	}
+
+	if ft, ok := shebangType(text); ok {
+		return FileTypes{ft}
+	}
+	if result := markupTypes(text); result != nil {
+		return result
+	}
+	if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if isJson(data, probe) {
+			return FileTypes{jsonType.fileType(confidenceJson, "valid JSON object or array")}
+		}
+	}
+	if ft, ok := keywordType(text); ok {
+		return FileTypes{ft}
+	}
+
+	return nil
+}
+
+// JSON is validated over all of data, decoding it again when the probe only covered its
+// start and it is not UTF-8
+func isJson(data []byte, probe textProbe) bool {
+	switch {
+	case len(data) > maxJsonSize:
+		return false
+	case probe.complete:
+		return json.Valid([]byte(probe.text))
+	case probe.encoding == EncodingAscii || probe.encoding == EncodingUtf8 || probe.encoding == EncodingUtf8Bom:
+		return json.Valid(data[probe.bomLength:])
+	}
+
+	text, ok := decodeAs(data[probe.bomLength:], probe.encoding)
+	return ok && json.Valid([]byte(text))
+}
+
+func shebangType(text string) (FileType, bool) {
+	if !strings.HasPrefix(text, "#!") {
+		return FileType{}, false
+	}
+
+	line := text[2:]
+	if end := strings.IndexAny(line, "\r\n"); end >= 0 {
+		line = line[:end]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return FileType{}, false
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		// Skip options such as -S
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interpreter = path.Base(f)
+				break
+			}
+		}
+	}
+
+	t, ok := shebangInterpreters[interpreterVersionRegex.ReplaceAllString(interpreter, "")]
+	if !ok {
+		// Still a script for an interpreter we do not classify
+		t = shellScriptType
+	}
+
+	return t.fileType(confidenceShebang, "shebang "+strings.TrimSpace(line)), true
+}
+
+func markupTypes(text string) FileTypes {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "<") {
+		return nil
+	}
+
+	switch {
+	case htaRegex.MatchString(text):
+		return FileTypes{
+			htmlType.fileType(confidenceMarkup, "HTA:APPLICATION tag"),
+			htaType.fileType(confidenceMarkup+0.05, "HTA:APPLICATION tag"),
+		}
+	case htmlRegex.MatchString(text):
+		return FileTypes{htmlType.fileType(confidenceMarkup, "HTML tag "+strings.ToLower(htmlRegex.FindString(text))+">")}
+	case svgRegex.MatchString(text):
+		result := FileTypes{svgType.fileType(confidenceMarkup+0.05, "SVG root element")}
+		if strings.HasPrefix(trimmed, "<?xml") {
+			result = append(FileTypes{xmlType.fileType(confidenceMarkup, "XML declaration")}, result...)
+		}
+		return result
+	case strings.HasPrefix(trimmed, "<?xml"):
+		return FileTypes{xmlType.fileType(confidenceMarkup, "XML declaration")}
+	}
+
+	return nil
+}
+
+// Scores each language by the weight of the distinct keywords it matches. The highest
+// score wins, with ties going to the language listed first.
+func keywordType(text string) (FileType, bool) {
+	var best *keywordLanguage
+	var bestWeight int
+	var bestNames []string
+
+	for i := range keywordLanguages {
+		l := &keywordLanguages[i]
+
+		weight := 0
+		var names []string
+		for _, k := range l.Keywords {
+			if k.Regex.MatchString(text) {
+				weight += k.Weight
+				names = append(names, k.Name)
+			}
+		}
+
+		if weight > bestWeight {
+			best, bestWeight, bestNames = l, weight, names
+		}
+	}
+
+	if best == nil || bestWeight < minKeywordWeight {
+		return FileType{}, false
+	}
+
+	confidence := confidenceText + confidenceKeyword*float64(bestWeight)
+	if confidence > confidenceMaxKeywords {
+		confidence = confidenceMaxKeywords
+	}
+
+	sort.Strings(bestNames)
+	return best.Type.fileType(confidence, "keywords "+strings.Join(bestNames, ", ")), true
+}
@@ -0,0 +1,341 @@
+package filetype
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+// Upper bounds for reading zip contents during detection
+const (
+	maxZipEntries   = 10000
+	maxZipEntrySize = 1024 * 1024
+	// The end of central directory record is followed by a comment of at most 64K
+	maxEocdSearch = 22 + 0xFFFF
+)
+
+const (
+	zipLocalHeader  = 0x04034B50
+	zipCentralEntry = 0x02014B50
+	zipEocd         = 0x06054B50
+)
+
+type zipEntry struct {
+	Name           string
+	Method         uint16
+	CompressedSize int
+	// Offset of the local file header
+	Offset int
+}
+
+// Lists the entries of a zip from its central directory. Files cut short before the
+// central directory, common for samples carved out of memory or traffic, fall back to
+// walking the local file headers.
+func readZipEntries(p []byte) []zipEntry {
+	if entries, ok := readZipCentralDirectory(p); ok {
+		return entries
+	}
+
+	return readZipLocalHeaders(p)
+}
+
+func findZipEocd(p []byte) int {
+	start := len(p) - maxEocdSearch
+	if start < 0 {
+		start = 0
+	}
+
+	for i := len(p) - 22; i >= start; i-- {
+		if v, _ := binary.Uint32Le(p, i); v == zipEocd {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func readZipCentralDirectory(p []byte) ([]zipEntry, bool) {
+	eocd := findZipEocd(p)
+	if eocd < 0 {
+		return nil, false
+	}
+
+	count, ok1 := binary.Uint16Le(p, eocd+10)
+	size, ok2 := binary.Uint32Le(p, eocd+12)
+	offset, ok3 := binary.Uint32Le(p, eocd+16)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, false
+	}
+
+	// Data prepended to the archive, like a self-extractor stub, shifts every offset
+	shift := eocd - int(size) - int(offset)
+	if shift < 0 {
+		shift = 0
+	}
+
+	var entries []zipEntry
+	o := int(offset) + shift
+	for i := 0; i < int(count) && i < maxZipEntries; i++ {
+		signature, ok := binary.Uint32Le(p, o)
+		if !ok || signature != zipCentralEntry {
+			break
+		}
+
+		method, ok1 := binary.Uint16Le(p, o+10)
+		compressedSize, ok2 := binary.Uint32Le(p, o+20)
+		nameLength, ok3 := binary.Uint16Le(p, o+28)
+		extraLength, ok4 := binary.Uint16Le(p, o+30)
+		commentLength, ok5 := binary.Uint16Le(p, o+32)
+		localOffset, ok6 := binary.Uint32Le(p, o+42)
+		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 || o+46+int(nameLength) > len(p) {
+			break
+		}
+
+		entries = append(entries, zipEntry{
+			Name:           string(p[o+46 : o+46+int(nameLength)]),
+			Method:         method,
+			CompressedSize: int(compressedSize),
+			Offset:         int(localOffset) + shift,
+		})
+
+		o += 46 + int(nameLength) + int(extraLength) + int(commentLength)
+	}
+
+	return entries, len(entries) > 0
+}
+
+func readZipLocalHeaders(p []byte) []zipEntry {
+	var entries []zipEntry
+	for o := 0; len(entries) < maxZipEntries; {
+		signature, ok := binary.Uint32Le(p, o)
+		if !ok || signature != zipLocalHeader {
+			break
+		}
+
+		flags, ok1 := binary.Uint16Le(p, o+6)
+		method, ok2 := binary.Uint16Le(p, o+8)
+		compressedSize, ok3 := binary.Uint32Le(p, o+18)
+		nameLength, ok4 := binary.Uint16Le(p, o+26)
+		extraLength, ok5 := binary.Uint16Le(p, o+28)
+		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || o+30+int(nameLength) > len(p) {
+			break
+		}
+
+		entries = append(entries, zipEntry{
+			Name:           string(p[o+30 : o+30+int(nameLength)]),
+			Method:         method,
+			CompressedSize: int(compressedSize),
+			Offset:         o,
+		})
+
+		// Sizes are only known after the data when the data descriptor flag is set
+		if flags&0x8 != 0 {
+			break
+		}
+		o += 30 + int(nameLength) + int(extraLength) + int(compressedSize)
+	}
+
+	return entries
+}
+
+// Reads up to maxZipEntrySize bytes of a stored or deflated entry. Truncated data is
+// returned as far as it goes.
+func readZipEntry(p []byte, e zipEntry) ([]byte, bool) {
+	nameLength, ok1 := binary.Uint16Le(p, e.Offset+26)
+	extraLength, ok2 := binary.Uint16Le(p, e.Offset+28)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+
+	start := e.Offset + 30 + int(nameLength) + int(extraLength)
+	if start > len(p) {
+		return nil, false
+	}
+	end := start + e.CompressedSize
+	if end > len(p) || end < start {
+		end = len(p)
+	}
+	data := p[start:end]
+
+	switch e.Method {
+	case 0:
+		if len(data) > maxZipEntrySize {
+			data = data[:maxZipEntrySize]
+		}
+		return data, true
+	case 8:
+		data, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), maxZipEntrySize))
+		if err != nil && len(data) == 0 {
+			return nil, false
+		}
+		return data, true
+	}
+
+	return nil, false
+}
+
+func findZipEntry(entries []zipEntry, name string) (zipEntry, bool) {
+	for _, e := range entries {
+		if strings.EqualFold(e.Name, name) {
+			return e, true
+		}
+	}
+
+	return zipEntry{}, false
+}
+
+var (
+	ooxmlType            = newFile(OpenOfficeXml, "Open Office XML", "application/zip", "docx", "pptx", "xlsx")
+	jarType              = newFile(Jar, "Java Archive", "application/java-archive", "jar")
+	apkType              = newFile(Apk, "Android Package", "application/vnd.android.package-archive", "apk")
+	browserExtensionType = newFile(BrowserExtension, "Browser Extension", "application/x-xpinstall", "xpi", "zip")
+)
+
+type zipType struct {
+	Id          Id
+	Description string
+	MimeType    string
+	Extension   string
+}
+
+// Main part content types in [Content_Types].xml
+var ooxmlContentTypes = map[string]zipType{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml":   {Docx, "Word Document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "docx"},
+	"application/vnd.ms-word.document.macroEnabled.main+xml":                             {Docm, "Macro-Enabled Word Document", "application/vnd.ms-word.document.macroEnabled.12", "docm"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml":         {Xlsx, "Excel Workbook", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx"},
+	"application/vnd.ms-excel.sheet.macroEnabled.main+xml":                               {Xlsm, "Macro-Enabled Excel Workbook", "application/vnd.ms-excel.sheet.macroEnabled.12", "xlsm"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml": {Pptx, "PowerPoint Presentation", "application/vnd.openxmlformats-officedocument.presentationml.presentation", "pptx"},
+	"application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml":                   {Pptm, "Macro-Enabled PowerPoint Presentation", "application/vnd.ms-powerpoint.presentation.macroEnabled.12", "pptm"},
+}
+
+var ooxmlContentTypeRegex = regexp.MustCompile(`ContentType="([^"]+\.main\+xml)"`)
+
+// Main parts, used when [Content_Types].xml cannot be read. The second type is used when
+// the package holds a VBA project.
+var ooxmlMainParts = []struct {
+	Name  string
+	Types [2]string
+}{
+	{"word/document.xml", [2]string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml", "application/vnd.ms-word.document.macroEnabled.main+xml"}},
+	{"xl/workbook.xml", [2]string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml", "application/vnd.ms-excel.sheet.macroEnabled.main+xml"}},
+	{"ppt/presentation.xml", [2]string{"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml", "application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml"}},
+}
+
+var odfExtensions = map[string]string{
+	"text":         "odt",
+	"spreadsheet":  "ods",
+	"presentation": "odp",
+	"graphics":     "odg",
+}
+
+const odfMimePrefix = "application/vnd.oasis.opendocument."
+
+func isZipContainer(p []byte) FileTypes {
+	if v, ok := binary.Uint32Le(p, 0); !ok || v != zipLocalHeader {
+		return nil
+	}
+
+	entries := readZipEntries(p)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if ft, ok := odfType(p, entries); ok {
+		return FileTypes{ft}
+	}
+	if result := ooxmlTypes(p, entries); result != nil {
+		return result
+	}
+
+	_, hasManifest := findZipEntry(entries, "META-INF/MANIFEST.MF")
+	_, hasAndroidManifest := findZipEntry(entries, "AndroidManifest.xml")
+	_, hasDex := findZipEntry(entries, "classes.dex")
+	switch {
+	case hasAndroidManifest && hasDex:
+		return FileTypes{withConfidence(apkType, confidenceApk)}
+	case hasManifest:
+		return FileTypes{withConfidence(jarType, confidenceJar)}
+	}
+
+	if isBrowserExtension(p, entries) {
+		return FileTypes{withConfidence(browserExtensionType, confidenceBrowserExtension)}
+	}
+
+	return nil
+}
+
+// ODF packages start with a stored mimetype entry naming the document type
+func odfType(p []byte, entries []zipEntry) (FileType, bool) {
+	e, ok := findZipEntry(entries, "mimetype")
+	if !ok {
+		return FileType{}, false
+	}
+
+	data, ok := readZipEntry(p, e)
+	mimeType := strings.TrimSpace(string(data))
+	if !ok || !strings.HasPrefix(mimeType, odfMimePrefix) {
+		return FileType{}, false
+	}
+
+	kind := strings.TrimSuffix(strings.TrimPrefix(mimeType, odfMimePrefix), "-template")
+	return newFileWithConfidence(OpenDocument, "OpenDocument", mimeType, confidenceOdf, odfExtensions[kind]), true
+}
+
+// Returns the generic type followed by the document type when the main part is known
+func ooxmlTypes(p []byte, entries []zipEntry) FileTypes {
+	e, ok := findZipEntry(entries, "[Content_Types].xml")
+	if !ok {
+		return nil
+	}
+
+	result := FileTypes{withConfidence(ooxmlType, confidenceOoxml)}
+
+	if data, ok := readZipEntry(p, e); ok {
+		for _, m := range ooxmlContentTypeRegex.FindAllStringSubmatch(string(data), -1) {
+			if t, ok := ooxmlContentTypes[m[1]]; ok {
+				return append(result, newFileWithConfidence(t.Id, t.Description, t.MimeType, confidenceOoxmlPart, t.Extension))
+			}
+		}
+	}
+
+	hasVba := false
+	for _, e := range entries {
+		if strings.HasSuffix(strings.ToLower(e.Name), "/vbaproject.bin") {
+			hasVba = true
+		}
+	}
+
+	for _, part := range ooxmlMainParts {
+		if _, ok := findZipEntry(entries, part.Name); ok {
+			contentType := part.Types[0]
+			if hasVba {
+				contentType = part.Types[1]
+			}
+			t := ooxmlContentTypes[contentType]
+			return append(result, newFileWithConfidence(t.Id, t.Description, t.MimeType, confidenceOoxmlPart, t.Extension))
+		}
+	}
+
+	return result
+}
+
+// WebExtensions, used by Firefox and unpacked Chrome extensions, have a manifest.json with
+// a manifest_version. Legacy Firefox add-ons have an install.rdf.
+func isBrowserExtension(p []byte, entries []zipEntry) bool {
+	if _, ok := findZipEntry(entries, "install.rdf"); ok {
+		return true
+	}
+
+	e, ok := findZipEntry(entries, "manifest.json")
+	if !ok {
+		return false
+	}
+
+	data, ok := readZipEntry(p, e)
+	return ok && bytes.Contains(data, []byte(`"manifest_version"`))
+}
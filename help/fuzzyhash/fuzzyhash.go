@@ -204,3 +204,114 @@ func Hash(p []byte) *FuzzyHash {
 		}
 	}
 }
+
+// Number of block sizes tracked by Hasher. The largest, MinBlockSize<<(numBlockSizes-1),
+// still leaves room for the doubled trigger block size in a uint32.
+const numBlockSizes = 30
+
+type blockSizeState struct {
+	H1pr  uint32
+	H2pr  uint32
+	I     uint32
+	J     uint32
+	Hash1 [SumSize]byte
+	Hash2 [SumSize / 2]byte
+}
+
+// Hasher computes the same fuzzy hash as Hash in a single pass over data written in
+// chunks. Every candidate block size is tracked at once, so memory use does not depend
+// on the amount of data written.
+type Hasher struct {
+	rh      RollingHash
+	trigger uint32
+	n       uint64
+	start   int
+	states  [numBlockSizes]blockSizeState
+}
+
+func NewHasher() *Hasher {
+	h := &Hasher{}
+	h.Reset()
+	return h
+}
+
+func (h *Hasher) Reset() {
+	*h = Hasher{}
+	for k := range h.states {
+		h.states[k].H1pr = Init
+		h.states[k].H2pr = Init
+	}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	for _, b := range p {
+		h.trigger = h.rh.Update(b)
+
+		blockSize := MinBlockSize << uint(h.start)
+		for k := h.start; k < numBlockSizes; k++ {
+			s := &h.states[k]
+			s.H1pr = sumHash(s.H1pr, b)
+			s.H2pr = sumHash(s.H2pr, b)
+
+			if h.trigger%blockSize == blockSize-1 {
+				s.Hash1[s.I] = base64Alphabet[s.H1pr%64]
+				if s.I < SumSize-1 {
+					s.H1pr = Init
+					s.I += 1
+				}
+			}
+
+			if h.trigger%(blockSize*2) == (blockSize*2)-1 {
+				s.Hash2[s.J] = base64Alphabet[s.H2pr%64]
+				if s.J < SumSize/2-1 {
+					s.H2pr = Init
+					s.J += 1
+				}
+			}
+
+			blockSize *= 2
+		}
+	}
+	h.n += uint64(len(p))
+
+	// Stop tracking block sizes that can no longer be selected: the selection starts at
+	// initialLevel and only moves down while a level has less than half a digest.
+	initial := h.initialLevel()
+	for h.start+1 <= initial && h.states[h.start+1].I >= SumSize/2 {
+		h.start++
+	}
+
+	return len(p), nil
+}
+
+func (h *Hasher) initialLevel() int {
+	level := 0
+	for level < numBlockSizes-1 && (uint64(MinBlockSize)<<uint(level))*uint64(SumSize) < h.n {
+		level++
+	}
+	return level
+}
+
+// Sum returns the fuzzy hash of all data written so far without changing the state.
+func (h *Hasher) Sum() *FuzzyHash {
+	level := h.initialLevel()
+	for level > h.start && h.states[level].I < SumSize/2 {
+		level--
+	}
+
+	s := h.states[level]
+	i := s.I
+	j := s.J
+	if h.trigger != 0 {
+		s.Hash1[i] = base64Alphabet[s.H1pr%64]
+		s.Hash2[j] = base64Alphabet[s.H2pr%64]
+		i += 1
+		j += 1
+	}
+
+	return &FuzzyHash{
+		Hash1:     string(s.Hash1[:i]),
+		Hash2:     string(s.Hash2[:j]),
+		BlockSize: MinBlockSize << uint(level),
+	}
+}
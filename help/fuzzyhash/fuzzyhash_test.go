@@ -3,6 +3,7 @@ package fuzzyhash
 import (
 	"encoding/hex"
 	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -1047,6 +1048,19 @@ func TestFuzzyHash(t *testing.T) {
 			failed = true
 		}
 
+		hasher := NewHasher()
+		for k := 0; k < len(data); k += 7 {
+			end := k + 7
+			if end > len(data) {
+				end = len(data)
+			}
+			hasher.Write(data[k:end])
+		}
+		if hs := hasher.Sum(); hs.String() != h.String() {
+			fmt.Printf("Hasher failure (%v):\n    Received: %v\n    Expected: %v\n", i/2+1, hs.String(), h.String())
+			failed = true
+		}
+
 		if lastHash != nil {
 			_ = h.Similarity(lastHash)
 		}
@@ -1068,3 +1082,28 @@ func TestFuzzyHash(t *testing.T) {
 		fmt.Printf("%v\n", SimilarityFromStrings(s4, s4))
 	*/
 }
+
+func TestHasherLarge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, size := range []int{4096, 100000, 1 << 21} {
+		data := make([]byte, size)
+		r.Read(data)
+		// Runs of repeated bytes exercise the smaller block sizes
+		for i := 0; i < size/4; i++ {
+			data[i] = byte(i / 1000)
+		}
+
+		hasher := NewHasher()
+		for k := 0; k < len(data); k += 4093 {
+			end := k + 4093
+			if end > len(data) {
+				end = len(data)
+			}
+			hasher.Write(data[k:end])
+		}
+
+		if expected, received := Hash(data).String(), hasher.Sum().String(); expected != received {
+			t.Fatalf("hasher mismatch (%v):\n    Received: %v\n    Expected: %v", size, received, expected)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package macho
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+var ErrNotMacho = errors.New("not a Mach-O file")
+var ErrTruncated = errors.New("truncated Mach-O file")
+
+// Upper bounds on table sizes, to keep malformed files from exhausting memory
+const (
+	MaxSlices       = 64
+	MaxLoadCommands = 4096
+)
+
+// Java class files share the fat magic number. Their version fields, read as an
+// architecture count, are at least 45, while universal binaries hold a few slices.
+const maxFatArchs = 30
+
+const (
+	magic32    = 0xFEEDFACE
+	magic64    = 0xFEEDFACF
+	magicFat   = 0xCAFEBABE
+	magicFat64 = 0xCAFEBABF
+
+	cpuArch64   = 0x01000000
+	cpuArch6432 = 0x02000000
+)
+
+const (
+	lcSegment         = 0x1
+	lcLoadDylib       = 0xC
+	lcIdDylib         = 0xD
+	lcSegment64       = 0x19
+	lcCodeSignature   = 0x1D
+	lcLazyLoadDylib   = 0x20
+	lcEncryptionInfo  = 0x21
+	lcEncryptionInfo6 = 0x2C
+	lcLoadWeakDylib   = 0x80000018
+	lcRpath           = 0x8000001C
+	lcReexportDylib   = 0x8000001F
+	lcMain            = 0x80000028
+)
+
+var cpuTypes = map[uint32]string{
+	7:                "i386",
+	7 | cpuArch64:    "x86_64",
+	12:               "arm",
+	12 | cpuArch64:   "arm64",
+	12 | cpuArch6432: "arm64_32",
+	18:               "powerpc",
+	18 | cpuArch64:   "powerpc64",
+}
+
+var fileTypes = map[uint32]string{
+	1:  "object",
+	2:  "executable",
+	3:  "fixed vm library",
+	4:  "core",
+	5:  "preload",
+	6:  "dylib",
+	7:  "dylinker",
+	8:  "bundle",
+	9:  "dylib stub",
+	10: "dsym",
+	11: "kext bundle",
+}
+
+var loadCommands = map[uint32]string{
+	0x1:        "LC_SEGMENT",
+	0x2:        "LC_SYMTAB",
+	0x4:        "LC_THREAD",
+	0x5:        "LC_UNIXTHREAD",
+	0xB:        "LC_DYSYMTAB",
+	0xC:        "LC_LOAD_DYLIB",
+	0xD:        "LC_ID_DYLIB",
+	0xE:        "LC_LOAD_DYLINKER",
+	0xF:        "LC_ID_DYLINKER",
+	0x19:       "LC_SEGMENT_64",
+	0x1B:       "LC_UUID",
+	0x1D:       "LC_CODE_SIGNATURE",
+	0x1E:       "LC_SEGMENT_SPLIT_INFO",
+	0x20:       "LC_LAZY_LOAD_DYLIB",
+	0x21:       "LC_ENCRYPTION_INFO",
+	0x24:       "LC_VERSION_MIN_MACOSX",
+	0x25:       "LC_VERSION_MIN_IPHONEOS",
+	0x26:       "LC_FUNCTION_STARTS",
+	0x29:       "LC_DATA_IN_CODE",
+	0x2A:       "LC_SOURCE_VERSION",
+	0x2C:       "LC_ENCRYPTION_INFO_64",
+	0x32:       "LC_BUILD_VERSION",
+	0x80000018: "LC_LOAD_WEAK_DYLIB",
+	0x8000001C: "LC_RPATH",
+	0x8000001F: "LC_REEXPORT_DYLIB",
+	0x80000022: "LC_DYLD_INFO_ONLY",
+	0x80000028: "LC_MAIN",
+	0x80000033: "LC_DYLD_EXPORTS_TRIE",
+	0x80000034: "LC_DYLD_CHAINED_FIXUPS",
+}
+
+type LoadCommand struct {
+	Command uint32
+	Name    string
+	Size    uint32
+}
+
+type Segment struct {
+	Name       string
+	Address    uint64
+	MemSize    uint64
+	FileOffset uint64
+	FileSize   uint64
+	MaxProt    uint32
+	InitProt   uint32
+}
+
+// Slice is one architecture of a universal binary, or the whole of a thin file
+type Slice struct {
+	CpuType    string
+	CpuTypeId  uint32
+	CpuSubtype uint32
+	// Position of the slice in a universal binary
+	Offset     uint64
+	Size       uint64
+	Bits       int
+	Endianness string
+	FileType   string
+	FileTypeId uint32
+	Flags      uint32
+
+	LoadCommands []LoadCommand `json:",omitempty"`
+	Segments     []Segment     `json:",omitempty"`
+
+	// Libraries from LC_LOAD_DYLIB and its weak, lazy and re-export variants
+	Dylibs      []string `json:",omitempty"`
+	InstallName string   `json:",omitempty"`
+	Rpaths      []string `json:",omitempty"`
+	// Offset of the entry point from LC_MAIN
+	EntryPoint uint64 `json:",omitempty"`
+
+	CodeSignature     bool
+	CodeSignatureSize uint32 `json:",omitempty"`
+	Encrypted         bool
+}
+
+type File struct {
+	Fat    bool
+	Slices []Slice
+}
+
+// IsFat reports whether data starts with a universal binary header rather than a Java
+// class file, which has the same magic number
+func IsFat(data []byte) bool {
+	magic, ok1 := binary.Uint32Be(data, 0)
+	count, ok2 := binary.Uint32Be(data, 4)
+	return ok1 && ok2 && (magic == magicFat || magic == magicFat64) && count > 0 && count < maxFatArchs
+}
+
+func isThin(data []byte) bool {
+	magic, ok := binary.Uint32Le(data, 0)
+	if !ok {
+		return false
+	}
+
+	switch magic {
+	case magic32, magic64:
+		return true
+	}
+
+	magic, _ = binary.Uint32Be(data, 0)
+	return magic == magic32 || magic == magic64
+}
+
+func IsMacho(data []byte) bool {
+	return IsFat(data) || isThin(data)
+}
+
+// Parse reads the slices of a universal binary, or the single slice of a thin file, with
+// their load commands
+func Parse(data []byte) (*File, error) {
+	if !IsFat(data) {
+		if !isThin(data) {
+			return nil, ErrNotMacho
+		}
+
+		s, err := parseSlice(data)
+		if err != nil {
+			return nil, err
+		}
+		s.Size = uint64(len(data))
+
+		return &File{Slices: []Slice{*s}}, nil
+	}
+
+	f := &File{Fat: true}
+
+	magic, _ := binary.Uint32Be(data, 0)
+	count, _ := binary.Uint32Be(data, 4)
+	if count > MaxSlices {
+		count = MaxSlices
+	}
+
+	archSize := 20
+	if magic == magicFat64 {
+		archSize = 32
+	}
+
+	for i := 0; i < int(count); i++ {
+		o := 8 + i*archSize
+
+		var offset, size uint64
+		var ok3, ok4 bool
+		if magic == magicFat64 {
+			offset, ok3 = binary.GetUint64(binary.BigEndian, data, o+8, 8)
+			size, ok4 = binary.GetUint64(binary.BigEndian, data, o+16, 8)
+		} else {
+			offset, ok3 = binary.GetUint64(binary.BigEndian, data, o+8, 4)
+			size, ok4 = binary.GetUint64(binary.BigEndian, data, o+12, 4)
+		}
+		cpuType, ok1 := binary.Uint32Be(data, o)
+		cpuSubtype, ok2 := binary.Uint32Be(data, o+4)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return f, ErrTruncated
+		}
+
+		s := &Slice{}
+		if offset < uint64(len(data)) {
+			end := offset + size
+			if end > uint64(len(data)) || end < offset {
+				end = uint64(len(data))
+			}
+
+			var err error
+			if s, err = parseSlice(data[offset:end]); err != nil {
+				// Keep what the fat header says about slices that cannot be parsed
+				s = &Slice{}
+			}
+		}
+
+		s.CpuTypeId = cpuType
+		s.CpuType = cpuTypeName(cpuType)
+		s.CpuSubtype = cpuSubtype & 0x00FFFFFF
+		s.Offset = offset
+		s.Size = size
+		f.Slices = append(f.Slices, *s)
+	}
+
+	return f, nil
+}
+
+func cpuTypeName(id uint32) string {
+	if name, ok := cpuTypes[id]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (0x%x)", id)
+}
+
+func cString(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+
+	return string(data)
+}
+
+type parser struct {
+	data       []byte
+	endianness binary.Endianness
+}
+
+func (p *parser) uint32(offset int) (uint32, bool) {
+	v, ok := binary.GetUint64(p.endianness, p.data, offset, 4)
+	return uint32(v), ok
+}
+
+func (p *parser) uint64(offset int) (uint64, bool) {
+	return binary.GetUint64(p.endianness, p.data, offset, 8)
+}
+
+// Reads an lc_str, an offset from the start of the load command to a string within it
+func (p *parser) lcString(command int, size uint32, field int) string {
+	offset, ok := p.uint32(command + field)
+	if !ok || offset >= size || command+int(size) > len(p.data) {
+		return ""
+	}
+
+	return cString(p.data[command+int(offset) : command+int(size)])
+}
+
+func parseSlice(data []byte) (*Slice, error) {
+	p := &parser{data: data}
+	s := &Slice{}
+
+	magic, ok := binary.Uint32Le(data, 0)
+	if !ok {
+		return nil, ErrNotMacho
+	}
+
+	switch magic {
+	case magic32, magic64:
+		p.endianness = binary.LittleEndian
+		s.Endianness = "little"
+	default:
+		magic, _ = binary.Uint32Be(data, 0)
+		if magic != magic32 && magic != magic64 {
+			return nil, ErrNotMacho
+		}
+		p.endianness = binary.BigEndian
+		s.Endianness = "big"
+	}
+
+	headerSize := 28
+	s.Bits = 32
+	if magic == magic64 {
+		headerSize = 32
+		s.Bits = 64
+	}
+
+	cpuType, ok1 := p.uint32(4)
+	cpuSubtype, ok2 := p.uint32(8)
+	fileType, ok3 := p.uint32(12)
+	numCommands, ok4 := p.uint32(16)
+	flags, ok5 := p.uint32(24)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, ErrTruncated
+	}
+
+	s.CpuTypeId = cpuType
+	s.CpuType = cpuTypeName(cpuType)
+	s.CpuSubtype = cpuSubtype & 0x00FFFFFF
+	s.FileTypeId = fileType
+	s.FileType = fileTypes[fileType]
+	if s.FileType == "" {
+		s.FileType = fmt.Sprintf("unknown (%v)", fileType)
+	}
+	s.Flags = flags
+
+	if numCommands > MaxLoadCommands {
+		numCommands = MaxLoadCommands
+	}
+
+	offset := headerSize
+	for i := 0; i < int(numCommands); i++ {
+		command, ok1 := p.uint32(offset)
+		size, ok2 := p.uint32(offset + 4)
+		if !ok1 || !ok2 || size < 8 {
+			break
+		}
+
+		name := loadCommands[command]
+		if name == "" {
+			name = fmt.Sprintf("unknown (0x%x)", command)
+		}
+		s.LoadCommands = append(s.LoadCommands, LoadCommand{Command: command, Name: name, Size: size})
+
+		switch command {
+		case lcLoadDylib, lcLoadWeakDylib, lcLazyLoadDylib, lcReexportDylib:
+			if dylib := p.lcString(offset, size, 8); dylib != "" {
+				s.Dylibs = append(s.Dylibs, dylib)
+			}
+		case lcIdDylib:
+			s.InstallName = p.lcString(offset, size, 8)
+		case lcRpath:
+			if rpath := p.lcString(offset, size, 8); rpath != "" {
+				s.Rpaths = append(s.Rpaths, rpath)
+			}
+		case lcCodeSignature:
+			s.CodeSignature = true
+			s.CodeSignatureSize, _ = p.uint32(offset + 12)
+		case lcMain:
+			s.EntryPoint, _ = p.uint64(offset + 8)
+		case lcEncryptionInfo, lcEncryptionInfo6:
+			cryptId, _ := p.uint32(offset + 16)
+			s.Encrypted = cryptId != 0
+		case lcSegment, lcSegment64:
+			p.parseSegment(s, command == lcSegment64, offset)
+		}
+
+		offset += int(size)
+	}
+
+	return s, nil
+}
+
+func (p *parser) parseSegment(s *Slice, is64 bool, offset int) {
+	if offset+24 > len(p.data) {
+		return
+	}
+
+	segment := Segment{Name: cString(p.data[offset+8 : offset+24])}
+	var oks [6]bool
+	if is64 {
+		segment.Address, oks[0] = p.uint64(offset + 24)
+		segment.MemSize, oks[1] = p.uint64(offset + 32)
+		segment.FileOffset, oks[2] = p.uint64(offset + 40)
+		segment.FileSize, oks[3] = p.uint64(offset + 48)
+		segment.MaxProt, oks[4] = p.uint32(offset + 56)
+		segment.InitProt, oks[5] = p.uint32(offset + 60)
+	} else {
+		var v [4]uint32
+		v[0], oks[0] = p.uint32(offset + 24)
+		v[1], oks[1] = p.uint32(offset + 28)
+		v[2], oks[2] = p.uint32(offset + 32)
+		v[3], oks[3] = p.uint32(offset + 36)
+		segment.Address, segment.MemSize, segment.FileOffset, segment.FileSize = uint64(v[0]), uint64(v[1]), uint64(v[2]), uint64(v[3])
+		segment.MaxProt, oks[4] = p.uint32(offset + 40)
+		segment.InitProt, oks[5] = p.uint32(offset + 44)
+	}
+
+	for _, ok := range oks {
+		if !ok {
+			return
+		}
+	}
+
+	s.Segments = append(s.Segments, segment)
+}
@@ -0,0 +1,73 @@
+package macho_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/macho"
+)
+
+func TestMacho(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/universal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !macho.IsMacho(data) || !macho.IsFat(data) {
+		t.Fatal("bad magic")
+	}
+
+	f, err := macho.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Fat || len(f.Slices) != 2 {
+		t.Fatalf("bad slices %+v", f)
+	}
+
+	executable := f.Slices[0]
+	if executable.CpuType != "x86_64" || executable.FileType != "executable" || executable.Bits != 64 || executable.Endianness != "little" || executable.Offset != 0x1000 {
+		t.Fatalf("bad executable header %+v", executable)
+	}
+	if !reflect.DeepEqual(executable.Dylibs, []string{"/usr/lib/libSystem.B.dylib"}) {
+		t.Fatalf("bad dylibs %v", executable.Dylibs)
+	}
+	if executable.EntryPoint != 0x1F80 || !executable.CodeSignature || executable.CodeSignatureSize != 0x40 {
+		t.Fatalf("bad executable %+v", executable)
+	}
+	if len(executable.LoadCommands) != 4 || executable.LoadCommands[3].Name != "LC_CODE_SIGNATURE" {
+		t.Fatalf("bad load commands %+v", executable.LoadCommands)
+	}
+
+	segment := macho.Segment{Name: "__TEXT", Address: 0x100000000, MemSize: 0x4000, FileSize: 0x200, MaxProt: 5, InitProt: 5}
+	if !reflect.DeepEqual(executable.Segments, []macho.Segment{segment}) {
+		t.Fatalf("bad segments %+v", executable.Segments)
+	}
+
+	dylib := f.Slices[1]
+	if dylib.CpuType != "arm64" || dylib.FileType != "dylib" || dylib.InstallName != "@rpath/libtest.dylib" || dylib.CodeSignature {
+		t.Fatalf("bad dylib %+v", dylib)
+	}
+	if !reflect.DeepEqual(dylib.Rpaths, []string{"@loader_path"}) {
+		t.Fatalf("bad rpaths %v", dylib.Rpaths)
+	}
+
+	// Thin files are a single slice
+	data, err = ioutil.ReadFile("testdata/executable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = macho.Parse(data)
+	if err != nil || f.Fat || len(f.Slices) != 1 || f.Slices[0].CpuType != "x86_64" {
+		t.Fatalf("bad thin file %+v %v", f, err)
+	}
+
+	// Java class files share the universal binary magic number
+	class := []byte{0xCA, 0xFE, 0xBA, 0xBE, 0x00, 0x00, 0x00, 0x34}
+	if macho.IsMacho(class) {
+		t.Fatal("bad class file match")
+	}
+	if _, err := macho.Parse(class); err != macho.ErrNotMacho {
+		t.Fatalf("bad class file error %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+// Package ole reads OLE2 compound files (Compound File Binary Format), the container used
+// by legacy Office documents, MSI installers and VBA projects.
+package ole
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+)
+
+var (
+	ErrNotOle         = errors.New("not an OLE compound file")
+	ErrCorrupt        = errors.New("corrupt OLE compound file")
+	ErrStreamNotFound = errors.New("stream not found")
+)
+
+const (
+	freeSector     = 0xFFFFFFFF
+	endOfChain     = 0xFFFFFFFE
+	noStream       = 0xFFFFFFFF
+	headerSize     = 512
+	dirEntrySize   = 128
+	numHeaderDifat = 109
+)
+
+const (
+	TypeEmpty   = 0
+	TypeStorage = 1
+	TypeStream  = 2
+	TypeRoot    = 5
+)
+
+type Entry struct {
+	Name  string
+	Path  string
+	Type  int
+	Clsid string `json:",omitempty"`
+	Size  uint64
+
+	startSector uint32
+	left        uint32
+	right       uint32
+	child       uint32
+}
+
+type File struct {
+	MajorVersion uint16
+	Entries      []*Entry
+
+	data             []byte
+	sectorSize       int
+	miniSectorSize   int
+	miniStreamCutoff uint32
+	fat              []uint32
+	miniFat          []uint32
+	miniStream       []byte
+}
+
+func IsOle(data []byte) bool {
+	v, ok := binary.Uint64Be(data, 0)
+	return ok && v == 0xD0CF11E0A1B11AE1
+}
+
+// Open parses the header, allocation tables and directory of a compound file
+func Open(data []byte) (*File, error) {
+	if !IsOle(data) || len(data) < headerSize {
+		return nil, ErrNotOle
+	}
+
+	f := &File{data: data}
+
+	f.MajorVersion, _ = binary.Uint16Le(data, 0x1A)
+	sectorShift, _ := binary.Uint16Le(data, 0x1E)
+	miniSectorShift, _ := binary.Uint16Le(data, 0x20)
+	if sectorShift < 7 || sectorShift > 16 || miniSectorShift > sectorShift {
+		return nil, ErrCorrupt
+	}
+	f.sectorSize = 1 << sectorShift
+	f.miniSectorSize = 1 << miniSectorShift
+
+	numFatSectors, _ := binary.Uint32Le(data, 0x2C)
+	firstDirSector, _ := binary.Uint32Le(data, 0x30)
+	f.miniStreamCutoff, _ = binary.Uint32Le(data, 0x38)
+	firstMiniFatSector, _ := binary.Uint32Le(data, 0x3C)
+	firstDifatSector, _ := binary.Uint32Le(data, 0x44)
+
+	if err := f.readFat(numFatSectors, firstDifatSector); err != nil {
+		return nil, err
+	}
+
+	dir, err := f.readChain(firstDirSector, -1)
+	if err != nil {
+		return nil, err
+	}
+	f.readDirectory(dir)
+	if len(f.Entries) == 0 || f.Entries[0].Type != TypeRoot {
+		return nil, ErrCorrupt
+	}
+
+	if firstMiniFatSector != endOfChain && firstMiniFatSector != freeSector {
+		miniFat, err := f.readChain(firstMiniFatSector, -1)
+		if err != nil {
+			return nil, err
+		}
+		f.miniFat = toUint32s(miniFat)
+	}
+
+	root := f.Entries[0]
+	if root.startSector != endOfChain && root.Size > 0 {
+		f.miniStream, err = f.readChain(root.startSector, int64(root.Size))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The root is the parent of every tree, never a node in one
+	visited := make([]bool, len(f.Entries))
+	visited[0] = true
+	if err := f.setPaths(root.child, "", visited); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func toUint32s(p []byte) []uint32 {
+	result := make([]uint32, 0, len(p)/4)
+	for i := 0; i+4 <= len(p); i += 4 {
+		v, _ := binary.Uint32Le(p, i)
+		result = append(result, v)
+	}
+
+	return result
+}
+
+func (f *File) sector(n uint32) ([]byte, bool) {
+	offset := (uint64(n) + 1) * uint64(f.sectorSize)
+	if offset+uint64(f.sectorSize) > uint64(len(f.data)) {
+		// The last sector may be cut short
+		if offset < uint64(len(f.data)) {
+			return f.data[offset:], true
+		}
+		return nil, false
+	}
+
+	return f.data[offset : offset+uint64(f.sectorSize)], true
+}
+
+func (f *File) numSectors() int {
+	return len(f.data)/f.sectorSize + 1
+}
+
+func (f *File) readFat(numFatSectors uint32, difatSector uint32) error {
+	var fatSectors []uint32
+	for i := 0; i < numHeaderDifat; i++ {
+		v, _ := binary.Uint32Le(f.data, 0x4C+i*4)
+		if v != freeSector {
+			fatSectors = append(fatSectors, v)
+		}
+	}
+
+	// Further FAT sector numbers are chained through DIFAT sectors, the last entry of
+	// each pointing to the next
+	perSector := f.sectorSize/4 - 1
+	for visited := 0; difatSector != endOfChain && difatSector != freeSector; visited++ {
+		if visited > f.numSectors() {
+			return ErrCorrupt
+		}
+
+		s, ok := f.sector(difatSector)
+		if !ok {
+			return ErrCorrupt
+		}
+
+		entries := toUint32s(s)
+		for i := 0; i < perSector && i < len(entries); i++ {
+			if entries[i] != freeSector {
+				fatSectors = append(fatSectors, entries[i])
+			}
+		}
+
+		if len(entries) <= perSector {
+			break
+		}
+		difatSector = entries[perSector]
+	}
+
+	if uint32(len(fatSectors)) > numFatSectors && numFatSectors > 0 {
+		fatSectors = fatSectors[:numFatSectors]
+	}
+
+	for _, n := range fatSectors {
+		s, ok := f.sector(n)
+		if !ok {
+			return ErrCorrupt
+		}
+		f.fat = append(f.fat, toUint32s(s)...)
+	}
+
+	return nil
+}
+
+// Follows a chain in the FAT, truncating the result to size when it is not negative
+func (f *File) readChain(start uint32, size int64) ([]byte, error) {
+	var result []byte
+	for n, visited := start, 0; n != endOfChain; visited++ {
+		if visited > len(f.fat) || int(n) >= len(f.fat) {
+			return nil, ErrCorrupt
+		}
+
+		s, ok := f.sector(n)
+		if !ok {
+			return nil, ErrCorrupt
+		}
+		result = append(result, s...)
+
+		if size >= 0 && int64(len(result)) >= size {
+			return result[:size], nil
+		}
+
+		n = f.fat[n]
+	}
+
+	if size >= 0 && int64(len(result)) > size {
+		result = result[:size]
+	}
+
+	return result, nil
+}
+
+// Follows a chain in the mini FAT
+func (f *File) readMiniChain(start uint32, size int64) ([]byte, error) {
+	var result []byte
+	for n, visited := start, 0; n != endOfChain; visited++ {
+		if visited > len(f.miniFat) || int(n) >= len(f.miniFat) {
+			return nil, ErrCorrupt
+		}
+
+		offset := int(n) * f.miniSectorSize
+		if offset+f.miniSectorSize > len(f.miniStream) {
+			return nil, ErrCorrupt
+		}
+		result = append(result, f.miniStream[offset:offset+f.miniSectorSize]...)
+
+		if int64(len(result)) >= size {
+			return result[:size], nil
+		}
+
+		n = f.miniFat[n]
+	}
+
+	return result, nil
+}
+
+func (f *File) readDirectory(dir []byte) {
+	for offset := 0; offset+dirEntrySize <= len(dir); offset += dirEntrySize {
+		e := dir[offset : offset+dirEntrySize]
+
+		nameLength, _ := binary.Uint16Le(e, 0x40)
+		if nameLength > 64 {
+			nameLength = 64
+		}
+		var name []uint16
+		for i := 0; i+1 < int(nameLength); i += 2 {
+			c, _ := binary.Uint16Le(e, i)
+			if c == 0 {
+				break
+			}
+			name = append(name, c)
+		}
+
+		entry := &Entry{Name: string(utf16.Decode(name)), Type: int(e[0x42])}
+		entry.left, _ = binary.Uint32Le(e, 0x44)
+		entry.right, _ = binary.Uint32Le(e, 0x48)
+		entry.child, _ = binary.Uint32Le(e, 0x4C)
+		entry.startSector, _ = binary.Uint32Le(e, 0x74)
+		entry.Size, _ = binary.Uint64Le(e, 0x78)
+		if f.MajorVersion == 3 {
+			// The high part of the size is unused in version 3 files
+			entry.Size &= 0xFFFFFFFF
+		}
+		if clsid := e[0x50:0x60]; string(clsid) != string(make([]byte, 16)) {
+			entry.Clsid = formatClsid(clsid)
+		}
+
+		f.Entries = append(f.Entries, entry)
+	}
+}
+
+func formatClsid(p []byte) string {
+	const hexDigits = "0123456789ABCDEF"
+	sb := &strings.Builder{}
+	// The first three fields are little endian
+	order := []int{3, 2, 1, 0, -1, 5, 4, -1, 7, 6, -1, 8, 9, -1, 10, 11, 12, 13, 14, 15}
+	for _, i := range order {
+		if i < 0 {
+			sb.WriteByte('-')
+			continue
+		}
+		sb.WriteByte(hexDigits[p[i]>>4])
+		sb.WriteByte(hexDigits[p[i]&0x0F])
+	}
+
+	return sb.String()
+}
+
+// Walks the red-black tree of siblings under a storage, setting the path of each entry.
+// An entry reached twice means the tree has a cycle.
+func (f *File) setPaths(index uint32, parent string, visited []bool) error {
+	if index == noStream {
+		return nil
+	}
+	if int(index) >= len(f.Entries) || visited[index] {
+		return ErrCorrupt
+	}
+	visited[index] = true
+
+	e := f.Entries[index]
+	e.Path = e.Name
+	if parent != "" {
+		e.Path = parent + "/" + e.Name
+	}
+
+	if err := f.setPaths(e.left, parent, visited); err != nil {
+		return err
+	}
+	if err := f.setPaths(e.right, parent, visited); err != nil {
+		return err
+	}
+	if e.Type == TypeStorage {
+		return f.setPaths(e.child, e.Path, visited)
+	}
+
+	return nil
+}
+
+// Find returns the entry at path, using "/" between storage names. Names are compared
+// case-insensitively, like the format does.
+func (f *File) Find(path string) (*Entry, bool) {
+	for _, e := range f.Entries {
+		if e.Path != "" && strings.EqualFold(e.Path, path) {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Streams returns every stream entry that is reachable from the root
+func (f *File) Streams() []*Entry {
+	var result []*Entry
+	for _, e := range f.Entries {
+		if e.Type == TypeStream && e.Path != "" {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+func (f *File) ReadEntry(e *Entry) ([]byte, error) {
+	if e.Type != TypeStream {
+		return nil, ErrStreamNotFound
+	}
+
+	if e.Size == 0 {
+		return []byte{}, nil
+	}
+
+	if e.Size < uint64(f.miniStreamCutoff) {
+		return f.readMiniChain(e.startSector, int64(e.Size))
+	}
+
+	return f.readChain(e.startSector, int64(e.Size))
+}
+
+func (f *File) ReadStream(path string) ([]byte, error) {
+	e, ok := f.Find(path)
+	if !ok {
+		return nil, ErrStreamNotFound
+	}
+
+	return f.ReadEntry(e)
+}
@@ -0,0 +1,104 @@
+package ole_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/ole"
+)
+
+func TestOle(t *testing.T) {
+	// A Word document with the VBA project of the vba package's test data
+	data, err := ioutil.ReadFile("testdata/macros.doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ole.IsOle(data) {
+		t.Fatal("bad detection")
+	}
+
+	f, err := ole.Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.MajorVersion != 3 {
+		t.Fatalf("bad version %v", f.MajorVersion)
+	}
+
+	var paths []string
+	for _, e := range f.Streams() {
+		paths = append(paths, e.Path)
+	}
+	expectedPaths := []string{"WordDocument", "Macros/VBA/dir", "Macros/VBA/ThisDocument", "Macros/VBA/Module1",
+		"Macros/VBA/_VBA_PROJECT", "Macros/PROJECT"}
+	if len(paths) != len(expectedPaths) {
+		t.Fatalf("bad streams %v", paths)
+	}
+	for i := range paths {
+		if paths[i] != expectedPaths[i] {
+			t.Fatalf("bad streams %v", paths)
+		}
+	}
+
+	project, err := ioutil.ReadFile("../vba/testdata/vbaProject.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := ole.Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streams under 4096 bytes are in the mini stream
+	for path, projectPath := range map[string]string{"macros/vba/DIR": "VBA/dir", "Macros/PROJECT": "PROJECT"} {
+		stream, err := f.ReadStream(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := pf.ReadStream(projectPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stream) == 0 || !bytes.Equal(stream, expected) {
+			t.Fatalf("bad stream %v", path)
+		}
+	}
+
+	word, err := f.ReadStream("WordDocument")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(word) != 6000 || !bytes.HasPrefix(word, []byte{0xEC, 0xA5}) {
+		t.Fatalf("bad large stream %x", word[:4])
+	}
+
+	if _, err := f.ReadStream("Macros/VBA"); err != ole.ErrStreamNotFound {
+		t.Fatalf("bad storage read error %v", err)
+	}
+	if _, err := f.ReadStream("Missing"); err != ole.ErrStreamNotFound {
+		t.Fatalf("bad missing stream error %v", err)
+	}
+
+	if _, err := ole.Open([]byte("not ole")); err != ole.ErrNotOle {
+		t.Fatalf("bad error %v", err)
+	}
+
+	if _, err := ole.Open(data[:1024]); err == nil {
+		t.Fatal("bad truncated file")
+	}
+}
+
+func TestCyclicDirectory(t *testing.T) {
+	// 39 entries with empty names under the root, each the left and right sibling of itself
+	data, err := ioutil.ReadFile("testdata/cyclic.doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ole.Open(data); err != ole.ErrCorrupt {
+		t.Fatalf("bad error %v", err)
+	}
+}
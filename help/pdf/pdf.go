@@ -0,0 +1,399 @@
+// Package pdf summarizes the structure of PDF documents, in the style of pdfid. Objects are
+// found by scanning for "obj" markers rather than by following the xref table, so that
+// documents with missing or broken cross references are still analyzed.
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var ErrNotPdf = errors.New("not a PDF document")
+var ErrUnsupportedFilter = errors.New("unsupported stream filter")
+
+// Upper bounds that keep malformed documents from exhausting memory
+const (
+	MaxObjects          = 1000000
+	MaxDecodedSize      = 64 * 1024 * 1024
+	MaxTotalDecodedSize = 256 * 1024 * 1024
+)
+
+// Readers accept a header anywhere in the first 1024 bytes
+const headerSearchSize = 1024
+
+// Keywords counts the occurrences of each of these names
+var Keywords = []string{
+	"/JavaScript",
+	"/JS",
+	"/OpenAction",
+	"/AA",
+	"/Launch",
+	"/EmbeddedFile",
+	"/URI",
+	"/ObjStm",
+}
+
+type EmbeddedFile struct {
+	// Number of the object holding the file data
+	Object int
+	Name   string `json:",omitempty"`
+	Size   int
+	Sha256 string
+	Data   []byte `json:"-"`
+}
+
+type File struct {
+	Version      string
+	HeaderOffset int
+	Objects      int
+	Streams      int
+	Pages        int
+	// Whether startxref points at a cross reference table or stream
+	XrefValid bool
+	// Occurrences of each name in Keywords, after undoing #xx escapes
+	Keywords      map[string]int
+	Urls          []string       `json:",omitempty"`
+	EmbeddedFiles []EmbeddedFile `json:",omitempty"`
+	// Streams that could not be fully decoded
+	StreamErrors int
+}
+
+var (
+	headerRegexp    = regexp.MustCompile(`%PDF-(\d+\.\d+)`)
+	objRegexp       = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	streamRegexp    = regexp.MustCompile(`\bstream\r?\n`)
+	nameRegexp      = regexp.MustCompile(`/[^\s/<>\[\]()%{}]+`)
+	nameEscape      = regexp.MustCompile(`#[0-9A-Fa-f]{2}`)
+	filterRegexp    = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/[^\s/<>\[\]()%{}]+)`)
+	pageRegexp      = regexp.MustCompile(`/Type\s*/Page\b`)
+	embeddedRegexp  = regexp.MustCompile(`/Type\s*/EmbeddedFile\b`)
+	objStmRegexp    = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	objStmNRegexp   = regexp.MustCompile(`/N\s+(\d+)`)
+	efRegexp        = regexp.MustCompile(`/EF\s*<<[^>]*/(?:UF|F)\s+(\d+)\s+\d+\s+R`)
+	fileNameRegexp  = regexp.MustCompile(`/(?:UF|F)\s*\(((?:\\.|[^\\)])*)\)`)
+	uriRegexp       = regexp.MustCompile(`/URI\s*\(((?:\\.|[^\\)])*)\)`)
+	urlRegexp       = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s"'<>()\\\x60]+`)
+	startXrefRegexp = regexp.MustCompile(`startxref\s+(\d+)`)
+	xrefTypeRegexp  = regexp.MustCompile(`/Type\s*/XRef\b`)
+)
+
+// IsPdf reports whether data has a PDF header in its first 1024 bytes
+func IsPdf(data []byte) bool {
+	return headerOffset(data) >= 0
+}
+
+func headerOffset(data []byte) int {
+	if len(data) > headerSearchSize {
+		data = data[:headerSearchSize]
+	}
+
+	return bytes.Index(data, []byte("%PDF-"))
+}
+
+// Undoes #xx escapes in names, which hide keywords such as /J#61vaScript
+func normalizeNames(p []byte) []byte {
+	return nameRegexp.ReplaceAllFunc(p, func(name []byte) []byte {
+		return nameEscape.ReplaceAllFunc(name, func(escape []byte) []byte {
+			b, _ := hex.DecodeString(string(escape[1:]))
+			return b
+		})
+	})
+}
+
+// Undoes the escapes of a literal string
+func unescapeString(s string) string {
+	var result []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			result = append(result, s[i])
+			continue
+		}
+
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 't':
+			result = append(result, '\t')
+		case '\r', '\n':
+			// Line continuation
+		default:
+			if c >= '0' && c <= '7' {
+				end := i
+				for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+					end++
+				}
+				v, _ := strconv.ParseUint(s[i:end], 8, 8)
+				result = append(result, byte(v))
+				i = end - 1
+			} else {
+				result = append(result, c)
+			}
+		}
+	}
+
+	return string(result)
+}
+
+type object struct {
+	number int
+	offset int
+	dict   []byte
+	stream []byte
+	// Decoded stream, nil when the object has no stream or decoding failed outright
+	decoded []byte
+}
+
+type parser struct {
+	data         []byte
+	file         *File
+	objects      []*object
+	decodedTotal int
+}
+
+// Parse summarizes the objects, pages and keywords of a PDF document
+func Parse(data []byte) (*File, error) {
+	offset := headerOffset(data)
+	if offset < 0 {
+		return nil, ErrNotPdf
+	}
+
+	p := &parser{data: data, file: &File{HeaderOffset: offset, Keywords: map[string]int{}}}
+	f := p.file
+
+	if m := headerRegexp.FindSubmatch(data[offset:]); m != nil {
+		f.Version = string(m[1])
+	}
+
+	for _, k := range Keywords {
+		f.Keywords[k] = 0
+	}
+
+	p.readObjects()
+
+	urls := map[string]bool{}
+	fileNames := map[int]string{}
+	for _, o := range p.objects {
+		p.scan(o.dict, urls)
+		if o.decoded != nil {
+			for _, m := range urlRegexp.FindAll(o.decoded, -1) {
+				urls[string(m)] = true
+			}
+		}
+
+		for _, m := range efRegexp.FindAllSubmatch(o.dict, -1) {
+			number, _ := strconv.Atoi(string(m[1]))
+			if name := fileNameRegexp.FindSubmatch(o.dict); name != nil {
+				fileNames[number] = unescapeString(string(name[1]))
+			} else if _, ok := fileNames[number]; !ok {
+				fileNames[number] = ""
+			}
+		}
+
+		// Object streams hold further objects, hidden from scanners that do not decode them
+		if objStmRegexp.Match(o.dict) && o.decoded != nil {
+			if m := objStmNRegexp.FindSubmatch(o.dict); m != nil {
+				n, _ := strconv.Atoi(string(m[1]))
+				f.Objects += n
+			}
+			content := normalizeNames(o.decoded)
+			p.scan(content, urls)
+			f.Pages += len(pageRegexp.FindAll(content, -1))
+		}
+	}
+
+	for _, o := range p.objects {
+		if !embeddedRegexp.Match(o.dict) {
+			continue
+		}
+
+		data := o.decoded
+		if data == nil {
+			data = o.stream
+		}
+		h := sha256.Sum256(data)
+		f.EmbeddedFiles = append(f.EmbeddedFiles, EmbeddedFile{
+			Object: o.number,
+			Name:   fileNames[o.number],
+			Size:   len(data),
+			Sha256: hex.EncodeToString(h[:]),
+			Data:   data,
+		})
+	}
+
+	for url := range urls {
+		f.Urls = append(f.Urls, url)
+	}
+	sort.Strings(f.Urls)
+
+	f.XrefValid = p.xrefValid()
+
+	return f, nil
+}
+
+func (p *parser) readObjects() {
+	matches := objRegexp.FindAllSubmatchIndex(p.data, MaxObjects)
+	for i, m := range matches {
+		end := len(p.data)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		body := p.data[m[1]:end]
+		if e := bytes.Index(body, []byte("endobj")); e >= 0 {
+			body = body[:e]
+		}
+
+		o := &object{}
+		o.number, _ = strconv.Atoi(string(p.data[m[2]:m[3]]))
+		o.offset = m[0]
+		o.dict = body
+
+		if s := streamRegexp.FindIndex(body); s != nil {
+			o.dict = body[:s[0]]
+			o.stream = body[s[1]:]
+			// The Length entry may be wrong or indirect, so rely on endstream
+			if e := bytes.LastIndex(o.stream, []byte("endstream")); e >= 0 {
+				o.stream = o.stream[:e]
+			}
+			o.stream = bytes.TrimSuffix(o.stream, []byte("\n"))
+			o.stream = bytes.TrimSuffix(o.stream, []byte("\r"))
+			p.file.Streams++
+		}
+
+		o.dict = normalizeNames(o.dict)
+		if o.stream != nil {
+			p.decode(o)
+		}
+
+		if pageRegexp.Match(o.dict) {
+			p.file.Pages++
+		}
+
+		p.objects = append(p.objects, o)
+	}
+
+	p.file.Objects += len(p.objects)
+}
+
+// Counts keywords and collects URI actions
+func (p *parser) scan(content []byte, urls map[string]bool) {
+	for _, name := range nameRegexp.FindAll(content, -1) {
+		if _, ok := p.file.Keywords[string(name)]; ok {
+			p.file.Keywords[string(name)]++
+		}
+	}
+
+	for _, m := range uriRegexp.FindAllSubmatch(content, -1) {
+		if uri := unescapeString(string(m[1])); uri != "" {
+			urls[uri] = true
+		}
+	}
+}
+
+func (p *parser) decode(o *object) {
+	m := filterRegexp.FindSubmatch(o.dict)
+	if m == nil {
+		o.decoded = o.stream
+		return
+	}
+
+	decoded := o.stream
+	for _, filter := range nameRegexp.FindAll(m[1], -1) {
+		var err error
+		decoded, err = decodeFilter(string(filter), decoded, MaxTotalDecodedSize-p.decodedTotal)
+		if err != nil {
+			p.file.StreamErrors++
+			// Keep what was decoded of streams that are truncated on purpose
+			if len(decoded) == 0 || err == ErrUnsupportedFilter {
+				return
+			}
+		}
+	}
+
+	p.decodedTotal += len(decoded)
+	o.decoded = decoded
+}
+
+func decodeFilter(filter string, p []byte, limit int) ([]byte, error) {
+	if limit > MaxDecodedSize {
+		limit = MaxDecodedSize
+	}
+	if limit <= 0 {
+		return nil, ErrUnsupportedFilter
+	}
+
+	switch filter {
+	case "/FlateDecode", "/Fl":
+		var r io.Reader
+		zr, err := zlib.NewReader(bytes.NewReader(p))
+		if err != nil {
+			// Some writers leave out the zlib header
+			r = flate.NewReader(bytes.NewReader(p))
+		} else {
+			r = zr
+		}
+		return ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+	case "/ASCIIHexDecode", "/AHx":
+		var digits []byte
+		for _, c := range p {
+			if c == '>' {
+				break
+			}
+			if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+				digits = append(digits, c)
+			}
+		}
+		if len(digits)%2 == 1 {
+			digits = append(digits, '0')
+		}
+		result := make([]byte, len(digits)/2)
+		_, err := hex.Decode(result, digits)
+		return result, err
+	case "/ASCII85Decode", "/A85":
+		if e := bytes.Index(p, []byte("~>")); e >= 0 {
+			p = p[:e]
+		}
+		// A "z" stands for four zero bytes, so the output may be larger than the input
+		return ioutil.ReadAll(io.LimitReader(ascii85.NewDecoder(bytes.NewReader(p)), int64(limit)))
+	}
+
+	return nil, ErrUnsupportedFilter
+}
+
+func (p *parser) xrefValid() bool {
+	matches := startXrefRegexp.FindAllSubmatch(p.data, -1)
+	if matches == nil {
+		return false
+	}
+
+	offset, err := strconv.Atoi(string(matches[len(matches)-1][1]))
+	if err != nil || offset >= len(p.data) {
+		return false
+	}
+
+	rest := p.data[offset:]
+	if bytes.HasPrefix(rest, []byte("xref")) {
+		return true
+	}
+
+	// A cross reference stream is an object whose dictionary has /Type /XRef
+	for _, o := range p.objects {
+		if o.offset == offset {
+			return xrefTypeRegexp.Match(o.dict)
+		}
+	}
+
+	return false
+}
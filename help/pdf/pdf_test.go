@@ -0,0 +1,105 @@
+package pdf_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/pdf"
+)
+
+func deflate(p string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write([]byte(p))
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+// The document has an auto-run script, a link, an embedded file and an object stream
+// hiding a page with a launch action. Its startxref is wrong, as is the Length of the
+// object stream.
+func TestPdf(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/objects.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pdf.IsPdf(data) {
+		t.Fatal("bad detection")
+	}
+
+	f, err := pdf.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Version != "1.7" || f.HeaderOffset != 0 {
+		t.Fatalf("bad header %v %v", f.Version, f.HeaderOffset)
+	} else if f.Objects != 9 || f.Streams != 2 || f.Pages != 2 {
+		t.Fatalf("bad counts %v %v %v", f.Objects, f.Streams, f.Pages)
+	} else if f.XrefValid || f.StreamErrors != 0 {
+		t.Fatalf("bad xref or stream errors %v %v", f.XrefValid, f.StreamErrors)
+	}
+
+	expectedKeywords := map[string]int{
+		"/JavaScript":   1,
+		"/JS":           1,
+		"/OpenAction":   1,
+		"/AA":           1,
+		"/Launch":       1,
+		"/EmbeddedFile": 1,
+		"/URI":          2,
+		"/ObjStm":       1,
+	}
+	if !reflect.DeepEqual(f.Keywords, expectedKeywords) {
+		t.Fatalf("bad keywords %v", f.Keywords)
+	}
+
+	if !reflect.DeepEqual(f.Urls, []string{"http://example.com/a)b", "http://hidden.example.net/x"}) {
+		t.Fatalf("bad urls %v", f.Urls)
+	}
+
+	if len(f.EmbeddedFiles) != 1 {
+		t.Fatalf("bad embedded files %+v", f.EmbeddedFiles)
+	} else if e := f.EmbeddedFiles[0]; e.Object != 7 || e.Name != "payload.exe" || string(e.Data) != "MZ payload" || e.Size != 10 {
+		t.Fatalf("bad embedded file %+v", e)
+	}
+
+	f, err = pdf.Parse(bytes.Replace(data, []byte("startxref\n1838\n"), []byte("startxref\n838\n"), 1))
+	if err != nil {
+		t.Fatal(err)
+	} else if !f.XrefValid {
+		t.Fatal("bad valid xref")
+	}
+
+	// Headers may follow junk, and truncated streams are decoded as far as possible
+	truncated := append([]byte("junk\n"), data...)
+	truncated = bytes.Replace(truncated, deflate("MZ payload"), deflate("MZ payload")[:8], 1)
+	f, err = pdf.Parse(truncated)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.HeaderOffset != 5 || f.Objects != 9 || f.StreamErrors != 1 {
+		t.Fatalf("bad truncated document %v %v %v", f.HeaderOffset, f.Objects, f.StreamErrors)
+	}
+
+	// Zero groups of ASCII85 streams are written as "z"
+	payload := append([]byte("MZ\x90\x00"), make([]byte, 4096)...)
+	encoded := make([]byte, ascii85.MaxEncodedLen(len(payload)))
+	encoded = encoded[:ascii85.Encode(encoded, payload)]
+	f, err = pdf.Parse([]byte(fmt.Sprintf("%%PDF-1.4\n1 0 obj\n<< /Type /EmbeddedFile /Filter /ASCII85Decode /Length %v >>\n"+
+		"stream\n%s~>\nendstream\nendobj\n%%%%EOF\n", len(encoded)+2, encoded)))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(f.EmbeddedFiles) != 1 || !bytes.Equal(f.EmbeddedFiles[0].Data, payload) || f.StreamErrors != 0 {
+		t.Fatalf("bad ASCII85 stream %v %v", len(f.EmbeddedFiles), f.StreamErrors)
+	}
+
+	if _, err := pdf.Parse([]byte("not a pdf")); err != pdf.ErrNotPdf {
+		t.Fatalf("bad error %v", err)
+	}
+}
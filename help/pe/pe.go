@@ -0,0 +1,525 @@
+package pe
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+	"github.com/gdcorp-infosec/threat-util/help/shannonentropy"
+)
+
+var ErrNotPe = errors.New("not a PE file")
+var ErrTruncated = errors.New("truncated PE file")
+
+// Upper bounds on table sizes, to keep malformed files from exhausting memory
+const (
+	MaxSections  = 96
+	MaxImports   = 4096
+	MaxFunctions = 65536
+)
+
+const (
+	characteristicDll = 0x2000
+
+	sectionExecute = 0x20000000
+	sectionWrite   = 0x80000000
+
+	ordinalFlag32 = 0x80000000
+	ordinalFlag64 = 0x8000000000000000
+)
+
+const (
+	AnomalyEntryPointOutsideSections = "entry point outside any section"
+	AnomalyWritableExecutableSection = "writable and executable section"
+	AnomalyZeroSizeRawData           = "section with zero size raw data"
+	AnomalyRawDataOutsideFile        = "section raw data outside file"
+	AnomalyNoSections                = "no sections"
+	AnomalyFutureTimestamp           = "compile timestamp in the future"
+)
+
+var machines = map[uint16]string{
+	0x014c: "i386",
+	0x0166: "mips",
+	0x01c0: "arm",
+	0x01c4: "armnt",
+	0x0200: "ia64",
+	0x8664: "amd64",
+	0xaa64: "arm64",
+}
+
+var subsystems = map[uint16]string{
+	1:  "native",
+	2:  "windows_gui",
+	3:  "windows_cui",
+	5:  "os2_cui",
+	7:  "posix_cui",
+	9:  "windows_ce_gui",
+	10: "efi_application",
+	11: "efi_boot_service_driver",
+	12: "efi_runtime_driver",
+	13: "efi_rom",
+	14: "xbox",
+	16: "windows_boot_application",
+}
+
+type Section struct {
+	Name             string
+	VirtualAddress   uint32
+	VirtualSize      uint32
+	PointerToRawData uint32
+	SizeOfRawData    uint32
+	Characteristics  uint32
+	Entropy          float64
+}
+
+type Import struct {
+	Library   string
+	Functions []string
+}
+
+type Export struct {
+	Name    string `json:",omitempty"`
+	Ordinal uint32
+	Rva     uint32
+}
+
+type File struct {
+	Machine         string
+	MachineId       uint16
+	Bitness         int
+	IsDll           bool
+	Subsystem       string
+	Characteristics uint16
+	Timestamp       time.Time
+	EntryPoint      uint32
+	ImageBase       uint64
+	SizeOfImage     uint32
+	SizeOfHeaders   uint32
+
+	Sections []Section
+
+	Imports    []Import `json:",omitempty"`
+	Imphash    string   `json:",omitempty"`
+	ExportName string   `json:",omitempty"`
+	Exports    []Export `json:",omitempty"`
+
+	Anomalies []string `json:",omitempty"`
+}
+
+type dataDirectory struct {
+	Rva  uint32
+	Size uint32
+}
+
+type parser struct {
+	data     []byte
+	file     *File
+	pe32Plus bool
+}
+
+// IsPe reports whether data starts with a DOS header pointing to a PE signature
+func IsPe(data []byte) bool {
+	_, ok := peHeaderOffset(data)
+	return ok
+}
+
+func peHeaderOffset(data []byte) (int, bool) {
+	if v, ok := binary.Uint16Be(data, 0); !ok || v != 0x4d5a {
+		return 0, false
+	}
+
+	peOffset, ok := binary.Uint32Le(data, 0x3C)
+	if !ok {
+		return 0, false
+	}
+
+	if v, ok := binary.Uint32Be(data, int(peOffset)); !ok || v != 0x50450000 {
+		return 0, false
+	}
+
+	return int(peOffset), true
+}
+
+// Parse reads the headers, section table, imports and exports of a PE file. Import and
+// export tables that cannot be read are skipped rather than treated as errors, since
+// malformed tables are common in malware.
+func Parse(data []byte) (*File, error) {
+	peOffset, ok := peHeaderOffset(data)
+	if !ok {
+		return nil, ErrNotPe
+	}
+
+	p := &parser{data: data, file: &File{}}
+	f := p.file
+
+	coff := peOffset + 4
+	machine, ok1 := binary.Uint16Le(data, coff)
+	numberOfSections, ok2 := binary.Uint16Le(data, coff+2)
+	timestamp, ok3 := binary.Uint32Le(data, coff+4)
+	sizeOfOptionalHeader, ok4 := binary.Uint16Le(data, coff+16)
+	characteristics, ok5 := binary.Uint16Le(data, coff+18)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, ErrTruncated
+	}
+
+	f.MachineId = machine
+	f.Machine = machines[machine]
+	if f.Machine == "" {
+		f.Machine = fmt.Sprintf("unknown (0x%04x)", machine)
+	}
+	f.Timestamp = time.Unix(int64(timestamp), 0).UTC()
+	f.Characteristics = characteristics
+	f.IsDll = characteristics&characteristicDll != 0
+
+	opt := coff + 20
+	magic, ok := binary.Uint16Le(data, opt)
+	if !ok {
+		return nil, ErrTruncated
+	}
+
+	var numberOfRvaAndSizesOffset int
+	switch magic {
+	case 0x10b:
+		f.Bitness = 32
+		imageBase, ok := binary.Uint32Le(data, opt+28)
+		if !ok {
+			return nil, ErrTruncated
+		}
+		f.ImageBase = uint64(imageBase)
+		numberOfRvaAndSizesOffset = 92
+	case 0x20b:
+		f.Bitness = 64
+		p.pe32Plus = true
+		imageBase, ok := binary.Uint64Le(data, opt+24)
+		if !ok {
+			return nil, ErrTruncated
+		}
+		f.ImageBase = imageBase
+		numberOfRvaAndSizesOffset = 108
+	default:
+		return nil, fmt.Errorf("unknown optional header magic 0x%04x", magic)
+	}
+
+	entryPoint, ok1 := binary.Uint32Le(data, opt+16)
+	sizeOfImage, ok2 := binary.Uint32Le(data, opt+56)
+	sizeOfHeaders, ok3 := binary.Uint32Le(data, opt+60)
+	subsystem, ok4 := binary.Uint16Le(data, opt+68)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, ErrTruncated
+	}
+
+	f.EntryPoint = entryPoint
+	f.SizeOfImage = sizeOfImage
+	f.SizeOfHeaders = sizeOfHeaders
+	f.Subsystem = subsystems[subsystem]
+	if f.Subsystem == "" {
+		f.Subsystem = fmt.Sprintf("unknown (%v)", subsystem)
+	}
+
+	var directories []dataDirectory
+	if n, ok := binary.Uint32Le(data, opt+numberOfRvaAndSizesOffset); ok {
+		for i := 0; i < int(n) && i < 16; i++ {
+			offset := opt + numberOfRvaAndSizesOffset + 4 + i*8
+			rva, ok1 := binary.Uint32Le(data, offset)
+			size, ok2 := binary.Uint32Le(data, offset+4)
+			if !ok1 || !ok2 || offset+8 > opt+int(sizeOfOptionalHeader) {
+				break
+			}
+			directories = append(directories, dataDirectory{Rva: rva, Size: size})
+		}
+	}
+
+	if err := p.parseSections(opt+int(sizeOfOptionalHeader), int(numberOfSections)); err != nil {
+		return nil, err
+	}
+
+	if len(directories) > 0 && directories[0].Rva != 0 {
+		p.parseExports(directories[0])
+	}
+
+	if len(directories) > 1 && directories[1].Rva != 0 {
+		p.parseImports(directories[1])
+		f.Imphash = Imphash(f.Imports)
+	}
+
+	p.checkAnomalies()
+
+	return f, nil
+}
+
+func (p *parser) parseSections(offset int, count int) error {
+	if count > MaxSections {
+		count = MaxSections
+	}
+
+	for i := 0; i < count; i++ {
+		s := offset + i*40
+		if s+40 > len(p.data) {
+			return ErrTruncated
+		}
+
+		section := Section{Name: strings.TrimRight(string(p.data[s:s+8]), "\x00")}
+		section.VirtualSize, _ = binary.Uint32Le(p.data, s+8)
+		section.VirtualAddress, _ = binary.Uint32Le(p.data, s+12)
+		section.SizeOfRawData, _ = binary.Uint32Le(p.data, s+16)
+		section.PointerToRawData, _ = binary.Uint32Le(p.data, s+20)
+		section.Characteristics, _ = binary.Uint32Le(p.data, s+36)
+
+		if raw := p.sectionData(section); len(raw) > 0 {
+			section.Entropy = shannonentropy.GetFromSlice(raw)
+		}
+
+		p.file.Sections = append(p.file.Sections, section)
+	}
+
+	return nil
+}
+
+// Returns the part of the section raw data present in the file
+func (p *parser) sectionData(s Section) []byte {
+	start := uint64(s.PointerToRawData)
+	end := start + uint64(s.SizeOfRawData)
+	if start >= uint64(len(p.data)) {
+		return nil
+	}
+	if end > uint64(len(p.data)) {
+		end = uint64(len(p.data))
+	}
+
+	return p.data[start:end]
+}
+
+// Converts a relative virtual address to a file offset
+func (p *parser) rvaToOffset(rva uint32) (int, bool) {
+	for _, s := range p.file.Sections {
+		size := s.VirtualSize
+		if s.SizeOfRawData > size {
+			size = s.SizeOfRawData
+		}
+
+		if rva >= s.VirtualAddress && uint64(rva) < uint64(s.VirtualAddress)+uint64(size) {
+			offset := uint64(rva-s.VirtualAddress) + uint64(s.PointerToRawData)
+			if offset >= uint64(len(p.data)) {
+				return 0, false
+			}
+			return int(offset), true
+		}
+	}
+
+	if rva < p.file.SizeOfHeaders && int(rva) < len(p.data) {
+		return int(rva), true
+	}
+
+	return 0, false
+}
+
+func (p *parser) cString(rva uint32, maxLength int) (string, bool) {
+	offset, ok := p.rvaToOffset(rva)
+	if !ok {
+		return "", false
+	}
+
+	end := offset
+	for end < len(p.data) && end-offset < maxLength && p.data[end] != 0 {
+		end++
+	}
+
+	return string(p.data[offset:end]), true
+}
+
+func (p *parser) parseImports(dir dataDirectory) {
+	offset, ok := p.rvaToOffset(dir.Rva)
+	if !ok {
+		return
+	}
+
+	functions := 0
+	for i := 0; i < MaxImports; i++ {
+		d := offset + i*20
+		originalFirstThunk, ok1 := binary.Uint32Le(p.data, d)
+		nameRva, ok2 := binary.Uint32Le(p.data, d+12)
+		firstThunk, ok3 := binary.Uint32Le(p.data, d+16)
+		if !ok1 || !ok2 || !ok3 || (originalFirstThunk == 0 && nameRva == 0 && firstThunk == 0) {
+			return
+		}
+
+		library, ok := p.cString(nameRva, 256)
+		if !ok {
+			continue
+		}
+
+		thunk := originalFirstThunk
+		if thunk == 0 {
+			thunk = firstThunk
+		}
+
+		imp := Import{Library: library}
+		thunkOffset, ok := p.rvaToOffset(thunk)
+		for ok && functions < MaxFunctions {
+			var value uint64
+			var isOrdinal bool
+			if p.pe32Plus {
+				value, ok = binary.Uint64Le(p.data, thunkOffset)
+				isOrdinal = value&ordinalFlag64 != 0
+				thunkOffset += 8
+			} else {
+				var v32 uint32
+				v32, ok = binary.Uint32Le(p.data, thunkOffset)
+				value = uint64(v32)
+				isOrdinal = v32&ordinalFlag32 != 0
+				thunkOffset += 4
+			}
+			if !ok || value == 0 {
+				break
+			}
+
+			if isOrdinal {
+				imp.Functions = append(imp.Functions, fmt.Sprintf("ord%d", value&0xFFFF))
+			} else if name, ok := p.cString(uint32(value)+2, 512); ok {
+				imp.Functions = append(imp.Functions, name)
+			} else {
+				break
+			}
+			functions++
+		}
+
+		p.file.Imports = append(p.file.Imports, imp)
+	}
+}
+
+func (p *parser) parseExports(dir dataDirectory) {
+	offset, ok := p.rvaToOffset(dir.Rva)
+	if !ok {
+		return
+	}
+
+	nameRva, ok1 := binary.Uint32Le(p.data, offset+12)
+	base, ok2 := binary.Uint32Le(p.data, offset+16)
+	numberOfFunctions, ok3 := binary.Uint32Le(p.data, offset+20)
+	numberOfNames, ok4 := binary.Uint32Le(p.data, offset+24)
+	addressOfFunctions, ok5 := binary.Uint32Le(p.data, offset+28)
+	addressOfNames, ok6 := binary.Uint32Le(p.data, offset+32)
+	addressOfNameOrdinals, ok7 := binary.Uint32Le(p.data, offset+36)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 || !ok7 {
+		return
+	}
+
+	p.file.ExportName, _ = p.cString(nameRva, 256)
+
+	if numberOfFunctions > MaxFunctions {
+		numberOfFunctions = MaxFunctions
+	}
+	if numberOfNames > numberOfFunctions {
+		numberOfNames = numberOfFunctions
+	}
+
+	functionsOffset, ok := p.rvaToOffset(addressOfFunctions)
+	if !ok {
+		return
+	}
+
+	names := map[uint32]string{}
+	namesOffset, ok1 := p.rvaToOffset(addressOfNames)
+	ordinalsOffset, ok2 := p.rvaToOffset(addressOfNameOrdinals)
+	if ok1 && ok2 {
+		for i := 0; i < int(numberOfNames); i++ {
+			rva, ok1 := binary.Uint32Le(p.data, namesOffset+i*4)
+			index, ok2 := binary.Uint16Le(p.data, ordinalsOffset+i*2)
+			if !ok1 || !ok2 {
+				break
+			}
+			if name, ok := p.cString(rva, 512); ok {
+				names[uint32(index)] = name
+			}
+		}
+	}
+
+	for i := uint32(0); i < numberOfFunctions; i++ {
+		rva, ok := binary.Uint32Le(p.data, functionsOffset+int(i)*4)
+		if !ok {
+			break
+		}
+		if rva == 0 {
+			continue
+		}
+		p.file.Exports = append(p.file.Exports, Export{Name: names[i], Ordinal: base + i, Rva: rva})
+	}
+}
+
+func (p *parser) checkAnomalies() {
+	f := p.file
+
+	add := func(anomaly string) {
+		for _, a := range f.Anomalies {
+			if a == anomaly {
+				return
+			}
+		}
+		f.Anomalies = append(f.Anomalies, anomaly)
+	}
+
+	if len(f.Sections) == 0 {
+		add(AnomalyNoSections)
+	}
+
+	if f.Timestamp.After(time.Now()) {
+		add(AnomalyFutureTimestamp)
+	}
+
+	entryPointInSection := false
+	for _, s := range f.Sections {
+		size := s.VirtualSize
+		if size == 0 {
+			size = s.SizeOfRawData
+		}
+		if f.EntryPoint >= s.VirtualAddress && uint64(f.EntryPoint) < uint64(s.VirtualAddress)+uint64(size) {
+			entryPointInSection = true
+		}
+
+		if s.Characteristics&sectionWrite != 0 && s.Characteristics&sectionExecute != 0 {
+			add(AnomalyWritableExecutableSection)
+		}
+
+		if s.SizeOfRawData == 0 {
+			add(AnomalyZeroSizeRawData)
+		} else if uint64(s.PointerToRawData)+uint64(s.SizeOfRawData) > uint64(len(p.data)) {
+			add(AnomalyRawDataOutsideFile)
+		}
+	}
+
+	if f.EntryPoint != 0 && !entryPointInSection {
+		add(AnomalyEntryPointOutsideSections)
+	}
+}
+
+// Imphash computes the import hash the same way as pefile: lower case "library.function"
+// pairs joined by commas, with dll/ocx/sys extensions removed and well known ordinals
+// replaced by their names.
+func Imphash(imports []Import) string {
+	var entries []string
+	for _, imp := range imports {
+		fullName := strings.ToLower(imp.Library)
+		library := fullName
+		if i := strings.LastIndexByte(library, '.'); i >= 0 {
+			switch library[i+1:] {
+			case "dll", "ocx", "sys":
+				library = library[:i]
+			}
+		}
+
+		for _, function := range imp.Functions {
+			entries = append(entries, library+"."+strings.ToLower(ordinalName(fullName, function)))
+		}
+	}
+
+	if len(entries) == 0 {
+		return ""
+	}
+
+	sum := md5.Sum([]byte(strings.Join(entries, ",")))
+	return hex.EncodeToString(sum[:])
+}
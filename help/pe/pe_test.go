@@ -0,0 +1,65 @@
+package pe_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/pe"
+)
+
+func TestPe(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/imports.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := pe.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Machine != "i386" || f.Bitness != 32 || f.IsDll || f.Subsystem != "windows_cui" {
+		t.Fatalf("bad headers: %+v", f)
+	} else if f.Timestamp.Unix() != 0x5F000000 || f.EntryPoint != 0x1000 || f.ImageBase != 0x400000 {
+		t.Fatalf("bad headers: %+v", f)
+	} else if len(f.Sections) != 2 || f.Sections[0].Name != ".text" || f.Sections[1].Name != ".data" {
+		t.Fatalf("bad sections: %+v", f.Sections)
+	}
+
+	expectedImports := []pe.Import{
+		{Library: "KERNEL32.dll", Functions: []string{"ExitProcess"}},
+		{Library: "WS2_32.dll", Functions: []string{"ord115"}},
+	}
+	if !reflect.DeepEqual(f.Imports, expectedImports) {
+		t.Fatalf("bad imports: %+v", f.Imports)
+	} else if f.Imphash != "60815f7d8cbc45b76fd9da8831006224" {
+		t.Fatalf("bad imphash: %v", f.Imphash)
+	} else if !reflect.DeepEqual(f.Anomalies, []string{pe.AnomalyWritableExecutableSection}) {
+		t.Fatalf("bad anomalies: %v", f.Anomalies)
+	}
+
+	if _, err := pe.Parse([]byte("MZ")); err != pe.ErrNotPe {
+		t.Fatalf("expected not a PE error")
+	}
+}
+
+func TestImphash(t *testing.T) {
+	// Ordinals are named from pefile's tables, which only cover .dll libraries
+	imports := []pe.Import{
+		{Library: "OLEAUT32.dll", Functions: []string{"ord200", "ord9", "ord1000"}},
+		{Library: "WS2_32.dll", Functions: []string{"ord40"}},
+		{Library: "wsock32.dll", Functions: []string{"ord24"}},
+		{Library: "WS2_32.ocx", Functions: []string{"ord115"}},
+	}
+
+	// md5 of "oleaut32.geterrorinfo,oleaut32.variantclear,oleaut32.ord1000,
+	// ws2_32.wsaenumprotocolsa,wsock32.getaddrinfow,ws2_32.ord115"
+	if imphash := pe.Imphash(imports); imphash != "111993271fe2105c57ab3782b6871cd7" {
+		t.Fatalf("bad imphash: %v", imphash)
+	}
+
+	if imphash := pe.Imphash(nil); imphash != "" {
+		t.Fatalf("bad empty imphash: %v", imphash)
+	}
+}
@@ -0,0 +1,222 @@
+package triage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+var ErrTooManyAbandoned = errors.New("too many abandoned processors are still running")
+
+// ContextProcessor is implemented by processors that can stop early when their context
+// ends. Other processors are abandoned once their deadline passes.
+type ContextProcessor interface {
+	Processor
+	TriageContext(context.Context, []byte)
+}
+
+type ConcurrentOptions struct {
+	// Maximum number of processors running at once, zero or less runs all of them at once.
+	// A processor abandoned after its timeout gives up its slot.
+	Workers int
+	// Time allowed for each processor, zero means no limit other than the context
+	Timeout time.Duration
+	// Maximum number of processors abandoned by the call that may still be running, zero or
+	// less means no limit. Once reached, the processors not started yet get a *TimeoutError
+	// wrapping ErrTooManyAbandoned.
+	MaxAbandoned int
+}
+
+// TimeoutError is set on each processor that did not finish before its timeout or before
+// the context ended, or that was not started because of ConcurrentOptions.MaxAbandoned.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return "processor did not finish: " + e.Err.Error()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+type errorSetter interface {
+	SetErr(error)
+}
+
+type concurrentResult struct {
+	index     int
+	processor Processor
+	cloned    bool
+	err       error
+}
+
+func (dp *DefaultProcessors) TriageContext(ctx context.Context, data []byte, options ConcurrentOptions) error {
+	*dp = DefaultProcessors{}
+	return TriageWithProcessorsContext(ctx, data, []Processor{&dp.Hashes, &dp.Size, &dp.ShannonEntropy, &dp.FileType, &dp.ByteDistribution, &dp.FuzzyHash, &dp.Time}, options)
+}
+
+// TriageWithProcessorsContext runs the processors in parallel. Each processor works on a
+// copy of itself which is copied back once it finishes, so a processor that is abandoned
+// after a timeout never writes to the caller's value. Processors that did not finish get a
+// *TimeoutError through SetErr.
+func TriageWithProcessorsContext(ctx context.Context, data []byte, processors []Processor, options ConcurrentOptions) error {
+	workers := options.Workers
+	if workers <= 0 || workers > len(processors) {
+		workers = len(processors)
+	}
+
+	maxAbandoned := int32(options.MaxAbandoned)
+	if maxAbandoned <= 0 || int(maxAbandoned) > len(processors) {
+		maxAbandoned = int32(len(processors))
+	}
+
+	results := make(chan concurrentResult, len(processors))
+	sem := make(chan struct{}, workers)
+	// Processors given up on that have not returned yet
+	var abandoned int32
+
+	started := 0
+	var notStartedErr error
+DispatchLoop:
+	for i, p := range processors {
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break DispatchLoop
+		}
+
+		// A slot is given back after its processor is counted as abandoned, so the count is
+		// up to date
+		if atomic.LoadInt32(&abandoned) >= maxAbandoned {
+			notStartedErr = ErrTooManyAbandoned
+			break
+		}
+
+		go func(i int, p Processor) {
+			r := runProcessor(ctx, i, p, data, options.Timeout, &abandoned)
+			<-sem
+			results <- r
+		}(i, p)
+		started++
+	}
+	if notStartedErr == nil {
+		notStartedErr = ctx.Err()
+	}
+
+	// Timeouts of processors that cannot hold an error
+	unset := map[int]error{}
+	for n := 0; n < started; n++ {
+		r := <-results
+		if r.err != nil {
+			// A processor that could not be copied may still be running
+			if !r.cloned || !setErr(processors[r.index], r.err) {
+				unset[r.index] = r.err
+			}
+			continue
+		}
+
+		if r.cloned {
+			reflect.ValueOf(processors[r.index]).Elem().Set(reflect.ValueOf(r.processor).Elem())
+		}
+	}
+
+	for i := started; i < len(processors); i++ {
+		err := &TimeoutError{Err: notStartedErr}
+		if !setErr(processors[i], err) {
+			unset[i] = err
+		}
+	}
+
+	var errs Errors
+	for i, p := range processors {
+		if err, ok := unset[i]; ok {
+			errs = append(errs, &ProcessorError{Processor: processorName(p), Err: err})
+		} else if p.HasAcceptedData() && p.Err() != nil {
+			errs = append(errs, &ProcessorError{Processor: processorName(p), Err: p.Err()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Runs a processor until it finishes or its context ends. abandoned counts the processor
+// from when runProcessor gives up on it until it returns.
+func runProcessor(ctx context.Context, index int, p Processor, data []byte, timeout time.Duration, abandoned *int32) concurrentResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	const (
+		running = iota
+		returned
+		givenUp
+	)
+
+	c, cloned := cloneProcessor(p)
+	state := int32(running)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if cp, ok := c.(ContextProcessor); ok {
+			cp.TriageContext(ctx, data)
+		} else {
+			c.Triage(data)
+		}
+
+		if !atomic.CompareAndSwapInt32(&state, running, returned) {
+			atomic.AddInt32(abandoned, -1)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		atomic.AddInt32(abandoned, 1)
+		if atomic.CompareAndSwapInt32(&state, running, givenUp) {
+			return concurrentResult{index: index, cloned: cloned, err: &TimeoutError{Err: ctx.Err()}}
+		}
+
+		// The processor returned at the same time
+		atomic.AddInt32(abandoned, -1)
+		<-done
+	}
+
+	return concurrentResult{index: index, processor: c, cloned: cloned}
+}
+
+// Returns a shallow copy of a processor that points to a struct, or the processor itself
+// when it cannot be copied
+func cloneProcessor(p Processor) (Processor, bool) {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return p, false
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+
+	return c.Interface().(Processor), true
+}
+
+func setErr(p Processor, err error) bool {
+	es, ok := p.(errorSetter)
+	if ok {
+		es.SetErr(err)
+	}
+
+	return ok
+}
@@ -0,0 +1,96 @@
+package triage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// ProcessorError wraps the error reported by a single processor. Path is set for
+// samples found inside archives by TriageRecursive.
+type ProcessorError struct {
+	Path      string `json:",omitempty"`
+	Processor string
+	Err       error
+}
+
+func (e *ProcessorError) Error() string {
+	if e.Path != "" {
+		return e.Path + ": " + e.Processor + ": " + e.Err.Error()
+	}
+
+	return e.Processor + ": " + e.Err.Error()
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// Errors holds the errors of every failing processor in a triage run. errors.Is and
+// errors.As look through each of them.
+type Errors []*ProcessorError
+
+func (e Errors) Error() string {
+	a := make([]string, 0, len(e))
+	for _, pe := range e {
+		a = append(a, pe.Error())
+	}
+
+	return strings.Join(a, "; ")
+}
+
+func (e Errors) Is(target error) bool {
+	for _, pe := range e {
+		if errors.Is(pe, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e Errors) As(target interface{}) bool {
+	for _, pe := range e {
+		if errors.As(pe, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the registered name of a processor, or its type name
+func processorName(p Processor) string {
+	if name, ok := registeredName(p); ok {
+		return name
+	}
+
+	t := reflect.TypeOf(p)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
+
+func collectErrors(processors []Processor) Errors {
+	var result Errors
+	for _, p := range processors {
+		if p.HasAcceptedData() {
+			if err := p.Err(); err != nil {
+				result = append(result, &ProcessorError{Processor: processorName(p), Err: err})
+			}
+		}
+	}
+
+	return result
+}
+
+// Returns an untyped nil when no processor failed
+func checkErrors(processors []Processor) error {
+	if errs := collectErrors(processors); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
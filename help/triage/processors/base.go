@@ -12,3 +12,8 @@ func (pb *ProcessorBase) Err() error {
 func (pb *ProcessorBase) HasAcceptedData() bool {
 	return pb.AcceptedData
 }
+
+func (pb *ProcessorBase) SetErr(err error) {
+	pb.Error = err
+	pb.AcceptedData = true
+}
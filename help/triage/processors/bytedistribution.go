@@ -4,6 +4,8 @@ type ByteDistribution struct {
 	ProcessorBase
 
 	ByteDistribution map[byte]int
+
+	counts [256]int
 }
 
 func (bd *ByteDistribution) Triage(p []byte) {
@@ -19,3 +21,22 @@ func (bd *ByteDistribution) Triage(p []byte) {
 
 	bd.AcceptedData = true
 }
+
+func (bd *ByteDistribution) Begin() {
+	bd.counts = [256]int{}
+}
+
+func (bd *ByteDistribution) Update(p []byte) {
+	for _, b := range p {
+		bd.counts[b] += 1
+	}
+}
+
+func (bd *ByteDistribution) End() {
+	bd.ByteDistribution = map[byte]int{}
+	for i := 0; i < len(bd.counts); i++ {
+		bd.ByteDistribution[byte(i)] = bd.counts[i]
+	}
+
+	bd.AcceptedData = true
+}
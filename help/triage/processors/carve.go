@@ -0,0 +1,45 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/filetype"
+)
+
+const DefaultCarveMaxCount = 1000
+
+// Carve finds files embedded at non-zero offsets, such as payloads appended to images or
+// documents.
+type Carve struct {
+	ProcessorBase
+
+	// Options, zero values use the defaults
+	CarveMaxCount int
+
+	CarvedFiles          []filetype.CarvedFile
+	CarvedFilesTruncated bool
+}
+
+func (c *Carve) Triage(p []byte) {
+	maxCount := c.CarveMaxCount
+	if maxCount <= 0 {
+		maxCount = DefaultCarveMaxCount
+	}
+
+	c.CarvedFiles = nil
+	c.CarvedFilesTruncated = false
+
+	// The file itself is reported by the filetype processor, so leave it out of the count
+	carved, truncated := filetype.Carve(p, maxCount+1)
+	for _, cf := range carved {
+		if cf.Offset == 0 {
+			continue
+		}
+		if len(c.CarvedFiles) == maxCount {
+			truncated = true
+			break
+		}
+		c.CarvedFiles = append(c.CarvedFiles, cf)
+	}
+	c.CarvedFilesTruncated = truncated
+
+	c.AcceptedData = true
+}
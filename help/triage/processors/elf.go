@@ -0,0 +1,22 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/elf"
+)
+
+// Elf parses ELF headers, segments and sections. Other file types are ignored.
+type Elf struct {
+	ProcessorBase
+
+	Elf *elf.File `json:",omitempty"`
+}
+
+func (e *Elf) Triage(data []byte) {
+	e.Elf = nil
+	if !elf.IsElf(data) {
+		return
+	}
+
+	e.Elf, e.Error = elf.Parse(data)
+	e.AcceptedData = true
+}
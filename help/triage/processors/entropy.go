@@ -8,6 +8,8 @@ type ShannonEntropy struct {
 	ProcessorBase
 
 	ShannonEntropy float64
+
+	counts [256]uint64
 }
 
 func (e *ShannonEntropy) Triage(p []byte) {
@@ -19,3 +21,18 @@ func (e *ShannonEntropy) Triage(p []byte) {
 	e.ShannonEntropy = shannonentropy.Compute(d)
 	e.AcceptedData = true
 }
+
+func (e *ShannonEntropy) Begin() {
+	e.counts = [256]uint64{}
+}
+
+func (e *ShannonEntropy) Update(p []byte) {
+	for _, b := range p {
+		e.counts[b] += 1
+	}
+}
+
+func (e *ShannonEntropy) End() {
+	e.ShannonEntropy = shannonentropy.Compute(e.counts)
+	e.AcceptedData = true
+}
@@ -4,16 +4,40 @@ import (
 	"github.com/gdcorp-infosec/threat-util/help/filetype"
 )
 
+// Number of leading bytes kept when a sample is streamed. File types are detected from
+// this prefix only, so formats identified by data further into the file may be missed.
+const FileTypeHeaderSize = 64 * 1024
+
 type FileType struct {
 	ProcessorBase
 
 	FileType  filetype.FileType
 	FileTypes filetype.FileTypes
+
+	header []byte
 }
 
 func (ft *FileType) Triage(p []byte) {
 	ft.FileTypes = filetype.Get(p)
-	ft.FileType = ft.FileTypes[0]
+	ft.FileType = ft.FileTypes.Best()
 
 	ft.AcceptedData = true
 }
+
+func (ft *FileType) Begin() {
+	ft.header = ft.header[:0]
+}
+
+func (ft *FileType) Update(p []byte) {
+	if n := FileTypeHeaderSize - len(ft.header); n > 0 {
+		if len(p) > n {
+			p = p[:n]
+		}
+		ft.header = append(ft.header, p...)
+	}
+}
+
+func (ft *FileType) End() {
+	ft.Triage(ft.header)
+	ft.header = nil
+}
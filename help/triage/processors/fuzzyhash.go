@@ -10,11 +10,28 @@ type FuzzyHash struct {
 	FuzzyHash1         string
 	FuzzyHash2         string
 	FuzzyHashBlockSize uint32
+
+	hasher *fuzzyhash.Hasher
 }
 
 func (fh *FuzzyHash) Triage(p []byte) {
-	h := fuzzyhash.Hash(p)
+	fh.set(fuzzyhash.Hash(p))
+}
+
+func (fh *FuzzyHash) Begin() {
+	fh.hasher = fuzzyhash.NewHasher()
+}
+
+func (fh *FuzzyHash) Update(p []byte) {
+	fh.hasher.Write(p)
+}
+
+func (fh *FuzzyHash) End() {
+	fh.set(fh.hasher.Sum())
+	fh.hasher = nil
+}
 
+func (fh *FuzzyHash) set(h *fuzzyhash.FuzzyHash) {
 	fh.FuzzyHash1 = h.Hash1
 	fh.FuzzyHash2 = h.Hash2
 	fh.FuzzyHashBlockSize = h.BlockSize
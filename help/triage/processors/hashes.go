@@ -1,6 +1,13 @@
 package processors
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+
 	"github.secureserver.net/threat/hashtype"
 )
 
@@ -10,6 +17,10 @@ type Hashes struct {
 	Md5HexDigest    string
 	Sha1HexDigest   string
 	Sha256HexDigest string
+
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
 }
 
 func (h *Hashes) Triage(p []byte) {
@@ -21,3 +32,30 @@ func (h *Hashes) Triage(p []byte) {
 
 	h.AcceptedData = true
 }
+
+func (h *Hashes) Begin() {
+	h.md5 = md5.New()
+	h.sha1 = sha1.New()
+	h.sha256 = sha256.New()
+}
+
+func (h *Hashes) Update(p []byte) {
+	h.md5.Write(p)
+	h.sha1.Write(p)
+	h.sha256.Write(p)
+}
+
+func (h *Hashes) End() {
+	h.Md5HexDigest = hexDigest(h.md5)
+	h.Sha1HexDigest = hexDigest(h.sha1)
+	h.Sha256HexDigest = hexDigest(h.sha256)
+
+	h.md5, h.sha1, h.sha256 = nil, nil, nil
+
+	h.AcceptedData = true
+}
+
+// Matches the upper case digests produced by hashtype
+func hexDigest(h hash.Hash) string {
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
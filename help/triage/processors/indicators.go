@@ -0,0 +1,260 @@
+package processors
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Minimum length of the strings scanned for indicators
+const indicatorsMinStringLength = 6
+
+// Upper bound on the number of strings scanned for indicators
+const indicatorsMaxStrings = 1000000
+
+type Indicator struct {
+	Value string
+	Count int
+}
+
+type Indicators struct {
+	ProcessorBase
+
+	Urls             []Indicator `json:",omitempty"`
+	Domains          []Indicator `json:",omitempty"`
+	Ipv4Addresses    []Indicator `json:",omitempty"`
+	Ipv6Addresses    []Indicator `json:",omitempty"`
+	EmailAddresses   []Indicator `json:",omitempty"`
+	Md5Hashes        []Indicator `json:",omitempty"`
+	Sha1Hashes       []Indicator `json:",omitempty"`
+	Sha256Hashes     []Indicator `json:",omitempty"`
+	BitcoinAddresses []Indicator `json:",omitempty"`
+}
+
+var (
+	refangHttp   = regexp.MustCompile(`(?i)\bhxxp`)
+	refangDot    = regexp.MustCompile(`(?i)\[\.\]|\(\.\)|\{\.\}|\[dot\]|\(dot\)`)
+	refangColon  = regexp.MustCompile(`\[:\]`)
+	refangAt     = regexp.MustCompile(`(?i)\[@\]|\[at\]|\(at\)`)
+	urlRegexp    = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s"'<>\x60]+`)
+	domainRegexp = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}\b`)
+	emailRegexp  = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@(?:[a-z0-9-]+\.)+[a-z]{2,63}\b`)
+	ipv4Regexp   = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	// Runs of hex digits, colons and dots, including compressed forms such as ::1 and
+	// ::ffff:10.0.0.1, which are checked by parsing them
+	ipv6Regexp   = regexp.MustCompile(`(?i)[0-9a-f:][0-9a-f:.]*:[0-9a-f:.]*`)
+	md5Regexp    = regexp.MustCompile(`(?i)\b[0-9a-f]{32}\b`)
+	sha1Regexp   = regexp.MustCompile(`(?i)\b[0-9a-f]{40}\b`)
+	sha256Regexp = regexp.MustCompile(`(?i)\b[0-9a-f]{64}\b`)
+	btcRegexp    = regexp.MustCompile(`\b(?:[13][1-9A-HJ-NP-Za-km-z]{25,34}|bc1[02-9ac-hj-np-z]{11,71})\b`)
+)
+
+// Undoes the usual ways of defanging indicators, such as hxxp and [.]
+func refang(s string) string {
+	s = refangHttp.ReplaceAllString(s, "http")
+	s = refangDot.ReplaceAllString(s, ".")
+	s = refangColon.ReplaceAllString(s, ":")
+	s = refangAt.ReplaceAllString(s, "@")
+
+	return s
+}
+
+type indicatorCounter map[string]int
+
+func (ic indicatorCounter) sorted() []Indicator {
+	var result []Indicator
+	for value, count := range ic {
+		result = append(result, Indicator{Value: value, Count: count})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	return result
+}
+
+func hasValidTld(domain string) bool {
+	i := strings.LastIndexByte(domain, '.')
+	return i >= 0 && topLevelDomains[strings.ToLower(domain[i+1:])]
+}
+
+// Names like "install.sh" and "libc.so" end in a TLD that is also a file extension, so
+// these are only domains with more labels or after the "//" of a URL
+func isLikelyDomain(s string, start int, end int) bool {
+	domain := s[start:end]
+	i := strings.LastIndexByte(domain, '.')
+	if !extensionTlds[strings.ToLower(domain[i+1:])] {
+		return true
+	}
+
+	return strings.Count(domain, ".") >= 2 || strings.HasSuffix(s[:start], "//")
+}
+
+func isWordByte(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+
+	c := s[i]
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (ind *Indicators) Triage(p []byte) {
+	urls := indicatorCounter{}
+	domains := indicatorCounter{}
+	ipv4s := indicatorCounter{}
+	ipv6s := indicatorCounter{}
+	emails := indicatorCounter{}
+	md5s := indicatorCounter{}
+	sha1s := indicatorCounter{}
+	sha256s := indicatorCounter{}
+	btcs := indicatorCounter{}
+
+	ascii, _ := extractAsciiStrings(p, indicatorsMinStringLength, indicatorsMaxStrings)
+	utf16, _ := extractUtf16LeStrings(p, indicatorsMinStringLength, indicatorsMaxStrings)
+
+	for _, es := range append(ascii, utf16...) {
+		s := refang(es.Value)
+
+		for _, m := range urlRegexp.FindAllString(s, -1) {
+			urls[strings.TrimRight(m, ".,;:)]}")] += 1
+		}
+
+		for _, m := range emailRegexp.FindAllString(s, -1) {
+			if hasValidTld(m) {
+				emails[strings.ToLower(m)] += 1
+			}
+		}
+
+		for _, loc := range domainRegexp.FindAllStringIndex(s, -1) {
+			m := s[loc[0]:loc[1]]
+			if hasValidTld(m) && net.ParseIP(m) == nil && isLikelyDomain(s, loc[0], loc[1]) {
+				domains[strings.ToLower(m)] += 1
+			}
+		}
+
+		for _, m := range ipv4Regexp.FindAllString(s, -1) {
+			if ip := net.ParseIP(m); ip != nil && ip.To4() != nil {
+				ipv4s[m] += 1
+			}
+		}
+
+		for _, loc := range ipv6Regexp.FindAllStringIndex(s, -1) {
+			// Parts of words, such as the "d::c" of "std::cout", are not addresses
+			if isWordByte(s, loc[0]-1) || isWordByte(s, loc[1]) {
+				continue
+			}
+			// A bare "::", such as a C++ scope operator or a Haskell type annotation, is the
+			// unspecified address
+			m := strings.TrimRight(s[loc[0]:loc[1]], ".")
+			if ip := net.ParseIP(m); ip != nil && !ip.IsUnspecified() {
+				ipv6s[strings.ToLower(m)] += 1
+			}
+		}
+
+		for _, m := range md5Regexp.FindAllString(s, -1) {
+			md5s[strings.ToLower(m)] += 1
+		}
+
+		for _, m := range sha1Regexp.FindAllString(s, -1) {
+			sha1s[strings.ToLower(m)] += 1
+		}
+
+		for _, m := range sha256Regexp.FindAllString(s, -1) {
+			sha256s[strings.ToLower(m)] += 1
+		}
+
+		for _, m := range btcRegexp.FindAllString(s, -1) {
+			if isBitcoinAddress(m) {
+				btcs[m] += 1
+			}
+		}
+	}
+
+	ind.Urls = urls.sorted()
+	ind.Domains = domains.sorted()
+	ind.Ipv4Addresses = ipv4s.sorted()
+	ind.Ipv6Addresses = ipv6s.sorted()
+	ind.EmailAddresses = emails.sorted()
+	ind.Md5Hashes = md5s.sorted()
+	ind.Sha1Hashes = sha1s.sorted()
+	ind.Sha256Hashes = sha256s.sorted()
+	ind.BitcoinAddresses = btcs.sorted()
+
+	ind.AcceptedData = true
+}
+
+func isBitcoinAddress(s string) bool {
+	if strings.HasPrefix(s, "bc1") {
+		return isBech32(s)
+	}
+
+	return isBase58Check(s)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Legacy (P2PKH and P2SH) addresses end in the first four bytes of a double SHA-256 of the payload
+func isBase58Check(s string) bool {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	decoded := n.Bytes()
+	for i := 0; i < len(s) && s[i] == '1'; i++ {
+		decoded = append([]byte{0}, decoded...)
+	}
+
+	if len(decoded) != 25 {
+		return false
+	}
+
+	h := sha256.Sum256(decoded[:21])
+	h = sha256.Sum256(h[:])
+
+	return string(h[:4]) == string(decoded[21:])
+}
+
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Segwit addresses carry a BIP 173 (bech32) or BIP 350 (bech32m) checksum
+func isBech32(s string) bool {
+	s = strings.ToLower(s)
+
+	values := []int{3, 3, 0, 2, 3} // Expanded human readable part "bc"
+	for _, c := range s[3:] {
+		i := strings.IndexRune(bech32Alphabet, c)
+		if i < 0 {
+			return false
+		}
+		values = append(values, i)
+	}
+
+	generator := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := 1
+	for _, v := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ v
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 != 0 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+
+	return checksum == 1 || checksum == 0x2bc830a3
+}
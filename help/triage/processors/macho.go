@@ -0,0 +1,23 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/macho"
+)
+
+// Macho parses Mach-O headers and load commands, including each slice of universal
+// binaries. Other file types are ignored.
+type Macho struct {
+	ProcessorBase
+
+	Macho *macho.File `json:",omitempty"`
+}
+
+func (m *Macho) Triage(data []byte) {
+	m.Macho = nil
+	if !macho.IsMacho(data) {
+		return
+	}
+
+	m.Macho, m.Error = macho.Parse(data)
+	m.AcceptedData = true
+}
@@ -0,0 +1,131 @@
+package processors
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gdcorp-infosec/threat-util/help/ole"
+	"github.com/gdcorp-infosec/threat-util/help/vba"
+)
+
+// Upper bound on the size of a vbaProject.bin read from an OOXML document
+const macrosMaxProjectSize = 64 * 1024 * 1024
+
+// Macros extracts VBA macros from OLE compound documents (.doc, .xls) and from the
+// vbaProject.bin part of OOXML documents (.docm, .xlsm). Other file types are ignored.
+type Macros struct {
+	ProcessorBase
+
+	VbaProjects []vba.Project `json:",omitempty"`
+	// Auto-exec entry points and suspicious keywords found across all modules
+	VbaAutoExec   []string `json:",omitempty"`
+	VbaSuspicious []string `json:",omitempty"`
+}
+
+func (m *Macros) Triage(data []byte) {
+	m.VbaProjects = nil
+	m.VbaAutoExec = nil
+	m.VbaSuspicious = nil
+
+	switch {
+	case ole.IsOle(data):
+		m.AcceptedData = true
+		m.VbaProjects, m.Error = oleProjects(data, "")
+	case isZip(data):
+		m.AcceptedData = true
+		m.VbaProjects, m.Error = ooxmlProjects(data)
+	default:
+		return
+	}
+
+	if m.Error == vba.ErrNoProject {
+		m.Error = nil
+	}
+
+	autoExec := map[string]bool{}
+	suspicious := map[string]bool{}
+	for _, p := range m.VbaProjects {
+		for _, module := range p.Modules {
+			for _, s := range module.AutoExec {
+				autoExec[s] = true
+			}
+			for _, s := range module.Suspicious {
+				suspicious[s] = true
+			}
+		}
+	}
+
+	m.VbaAutoExec = sortedKeys(autoExec)
+	m.VbaSuspicious = sortedKeys(suspicious)
+}
+
+func isZip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var result []string
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Reads the VBA projects of a compound file, prefixing their paths with prefix
+func oleProjects(data []byte, prefix string) ([]vba.Project, error) {
+	f, err := ole.Open(data)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := vba.Projects(f)
+	if prefix != "" {
+		for i := range projects {
+			projects[i].Path = prefix + "/" + projects[i].Path
+			for j := range projects[i].Modules {
+				projects[i].Modules[j].Stream = prefix + "/" + projects[i].Modules[j].Stream
+			}
+		}
+	}
+
+	return projects, err
+}
+
+func ooxmlProjects(data []byte) ([]vba.Project, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []vba.Project
+	for _, f := range zr.File {
+		if !strings.EqualFold(path.Base(f.Name), "vbaProject.bin") || f.UncompressedSize64 > macrosMaxProjectSize {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return projects, err
+		}
+		project, err := ioutil.ReadAll(io.LimitReader(rc, macrosMaxProjectSize))
+		rc.Close()
+		if err != nil {
+			return projects, err
+		}
+
+		found, err := oleProjects(project, f.Name)
+		projects = append(projects, found...)
+		if err != nil && err != vba.ErrNoProject {
+			return projects, err
+		}
+	}
+
+	return projects, nil
+}
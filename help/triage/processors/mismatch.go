@@ -0,0 +1,25 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/filetype"
+)
+
+// Mismatch compares the file name with the detected types and looks for polyglots. Without
+// a file name only polyglots are found.
+type Mismatch struct {
+	ProcessorBase
+
+	// Options, set for each child by TriageRecursive
+	FileName string `json:",omitempty"`
+
+	Mismatch *filetype.Mismatch `json:",omitempty"`
+}
+
+func (m *Mismatch) SetFileName(name string) {
+	m.FileName = name
+}
+
+func (m *Mismatch) Triage(p []byte) {
+	m.Mismatch = filetype.CheckMismatch(m.FileName, p)
+	m.AcceptedData = true
+}
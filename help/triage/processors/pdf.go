@@ -0,0 +1,22 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/pdf"
+)
+
+// Pdf summarizes PDF objects, pages and suspicious keywords. Other file types are ignored.
+type Pdf struct {
+	ProcessorBase
+
+	Pdf *pdf.File `json:",omitempty"`
+}
+
+func (p *Pdf) Triage(data []byte) {
+	p.Pdf = nil
+	if !pdf.IsPdf(data) {
+		return
+	}
+
+	p.Pdf, p.Error = pdf.Parse(data)
+	p.AcceptedData = true
+}
@@ -0,0 +1,22 @@
+package processors
+
+import (
+	"github.com/gdcorp-infosec/threat-util/help/pe"
+)
+
+// Pe parses PE headers, sections, imports and exports. Other file types are ignored.
+type Pe struct {
+	ProcessorBase
+
+	Pe *pe.File `json:",omitempty"`
+}
+
+func (p *Pe) Triage(data []byte) {
+	p.Pe = nil
+	if !pe.IsPe(data) {
+		return
+	}
+
+	p.Pe, p.Error = pe.Parse(data)
+	p.AcceptedData = true
+}
@@ -11,3 +11,15 @@ func (s *Size) Triage(p []byte) {
 
 	s.AcceptedData = true
 }
+
+func (s *Size) Begin() {
+	s.Size = 0
+}
+
+func (s *Size) Update(p []byte) {
+	s.Size += len(p)
+}
+
+func (s *Size) End() {
+	s.AcceptedData = true
+}
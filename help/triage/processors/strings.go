@@ -0,0 +1,173 @@
+package processors
+
+import (
+	"sort"
+
+	"github.com/gdcorp-infosec/threat-util/help/sscounter"
+)
+
+const (
+	DefaultStringsMinLength = 4
+	DefaultStringsMaxCount  = 10000
+	DefaultStringsMaxTokens = 20
+)
+
+const (
+	StringEncodingAscii   = "ascii"
+	StringEncodingUtf16Le = "utf-16le"
+)
+
+type ExtractedString struct {
+	Offset   int
+	Encoding string
+	Value    string
+}
+
+// Strings extracts printable ASCII and UTF-16LE strings, like the strings utility, and
+// reports the most frequent alphanumeric tokens found in them.
+type Strings struct {
+	ProcessorBase
+
+	// Options, zero values use the defaults
+	StringsMinLength int
+	StringsMaxCount  int
+	StringsMaxTokens int
+
+	Strings          []ExtractedString
+	StringsTruncated bool
+	StringsTopTokens []string
+}
+
+func isPrintableAscii(b byte) bool {
+	return (b >= 0x20 && b < 0x7F) || b == '\t'
+}
+
+func (s *Strings) Triage(p []byte) {
+	minLength := s.StringsMinLength
+	if minLength <= 0 {
+		minLength = DefaultStringsMinLength
+	}
+
+	maxCount := s.StringsMaxCount
+	if maxCount <= 0 {
+		maxCount = DefaultStringsMaxCount
+	}
+
+	maxTokens := s.StringsMaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultStringsMaxTokens
+	}
+
+	ascii, asciiTruncated := extractAsciiStrings(p, minLength, maxCount)
+	utf16, utf16Truncated := extractUtf16LeStrings(p, minLength, maxCount)
+
+	s.Strings = append(ascii, utf16...)
+	sort.SliceStable(s.Strings, func(i, j int) bool { return s.Strings[i].Offset < s.Strings[j].Offset })
+
+	s.StringsTruncated = asciiTruncated || utf16Truncated
+	if len(s.Strings) > maxCount {
+		s.Strings = s.Strings[:maxCount]
+		s.StringsTruncated = true
+	}
+
+	sc := sscounter.New(sscounter.CaseInsensitive, sscounter.DelimiterFuncNonAlphaNumeric)
+	for _, es := range s.Strings {
+		sc.Update(es.Value)
+	}
+	s.StringsTopTokens = topTokens(sc, maxTokens, 2)
+
+	s.AcceptedData = true
+}
+
+// Returns the n most frequent tokens seen at least minCount times, in the spelling seen
+// most often. Unlike SubstringCounter.TopN, ties are ordered by token, so identical data
+// always gives the same list.
+func topTokens(sc *sscounter.SubstringCounter, n int, minCount int) []string {
+	type token struct {
+		Value string
+		Count int
+	}
+
+	var tokens []token
+	for _, spellings := range sc.Counts {
+		t := token{}
+		best := 0
+		for spelling, count := range spellings {
+			t.Count += count
+			if count > best || (count == best && spelling < t.Value) {
+				t.Value, best = spelling, count
+			}
+		}
+		if t.Count >= minCount {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Count != tokens[j].Count {
+			return tokens[i].Count > tokens[j].Count
+		}
+		return tokens[i].Value < tokens[j].Value
+	})
+
+	var result []string
+	for i := 0; i < len(tokens) && i < n; i++ {
+		result = append(result, tokens[i].Value)
+	}
+
+	return result
+}
+
+func extractAsciiStrings(p []byte, minLength int, maxCount int) ([]ExtractedString, bool) {
+	var result []ExtractedString
+
+	start := -1
+	for i := 0; i <= len(p); i++ {
+		if i < len(p) && isPrintableAscii(p[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 && i-start >= minLength {
+			if len(result) == maxCount {
+				return result, true
+			}
+			result = append(result, ExtractedString{Offset: start, Encoding: StringEncodingAscii, Value: string(p[start:i])})
+		}
+		start = -1
+	}
+
+	return result, false
+}
+
+func extractUtf16LeStrings(p []byte, minLength int, maxCount int) ([]ExtractedString, bool) {
+	var result []ExtractedString
+
+	for i := 0; i+1 < len(p); {
+		end := i
+		for end+1 < len(p) && isPrintableAscii(p[end]) && p[end+1] == 0x00 {
+			end += 2
+		}
+
+		if (end-i)/2 < minLength {
+			i += 1
+			continue
+		}
+
+		if len(result) == maxCount {
+			return result, true
+		}
+
+		value := make([]byte, 0, (end-i)/2)
+		for k := i; k < end; k += 2 {
+			value = append(value, p[k])
+		}
+		result = append(result, ExtractedString{Offset: i, Encoding: StringEncodingUtf16Le, Value: string(value)})
+
+		i = end
+	}
+
+	return result, false
+}
@@ -13,3 +13,11 @@ func (t *Time) Triage(p []byte) {
 
 	t.AcceptedData = true
 }
+
+func (t *Time) Begin() {}
+
+func (t *Time) Update(p []byte) {}
+
+func (t *Time) End() {
+	t.Triage(nil)
+}
@@ -0,0 +1,68 @@
+package processors
+
+// Top level domains accepted by the Indicators processor: every country code TLD plus
+// the generic TLDs most often seen in samples
+var topLevelDomains = map[string]bool{
+	// Country code
+	"ac": true, "ad": true, "ae": true, "af": true, "ag": true, "ai": true, "al": true, "am": true,
+	"ao": true, "aq": true, "ar": true, "as": true, "at": true, "au": true, "aw": true, "ax": true,
+	"az": true, "ba": true, "bb": true, "bd": true, "be": true, "bf": true, "bg": true, "bh": true,
+	"bi": true, "bj": true, "bm": true, "bn": true, "bo": true, "br": true, "bs": true, "bt": true,
+	"bw": true, "by": true, "bz": true, "ca": true, "cc": true, "cd": true, "cf": true, "cg": true,
+	"ch": true, "ci": true, "ck": true, "cl": true, "cm": true, "cn": true, "co": true, "cr": true,
+	"cu": true, "cv": true, "cw": true, "cx": true, "cy": true, "cz": true, "de": true, "dj": true,
+	"dk": true, "dm": true, "do": true, "dz": true, "ec": true, "ee": true, "eg": true, "er": true,
+	"es": true, "et": true, "eu": true, "fi": true, "fj": true, "fk": true, "fm": true, "fo": true,
+	"fr": true, "ga": true, "gb": true, "gd": true, "ge": true, "gf": true, "gg": true, "gh": true,
+	"gi": true, "gl": true, "gm": true, "gn": true, "gp": true, "gq": true, "gr": true, "gs": true,
+	"gt": true, "gu": true, "gw": true, "gy": true, "hk": true, "hm": true, "hn": true, "hr": true,
+	"ht": true, "hu": true, "id": true, "ie": true, "il": true, "im": true, "in": true, "io": true,
+	"iq": true, "ir": true, "is": true, "it": true, "je": true, "jm": true, "jo": true, "jp": true,
+	"ke": true, "kg": true, "kh": true, "ki": true, "km": true, "kn": true, "kp": true, "kr": true,
+	"kw": true, "ky": true, "kz": true, "la": true, "lb": true, "lc": true, "li": true, "lk": true,
+	"lr": true, "ls": true, "lt": true, "lu": true, "lv": true, "ly": true, "ma": true, "mc": true,
+	"md": true, "me": true, "mg": true, "mh": true, "mk": true, "ml": true, "mm": true, "mn": true,
+	"mo": true, "mp": true, "mq": true, "mr": true, "ms": true, "mt": true, "mu": true, "mv": true,
+	"mw": true, "mx": true, "my": true, "mz": true, "na": true, "nc": true, "ne": true, "nf": true,
+	"ng": true, "ni": true, "nl": true, "no": true, "np": true, "nr": true, "nu": true, "nz": true,
+	"om": true, "pa": true, "pe": true, "pf": true, "pg": true, "ph": true, "pk": true, "pl": true,
+	"pm": true, "pn": true, "pr": true, "ps": true, "pt": true, "pw": true, "py": true, "qa": true,
+	"re": true, "ro": true, "rs": true, "ru": true, "rw": true, "sa": true, "sb": true, "sc": true,
+	"sd": true, "se": true, "sg": true, "sh": true, "si": true, "sk": true, "sl": true, "sm": true,
+	"sn": true, "so": true, "sr": true, "ss": true, "st": true, "su": true, "sv": true, "sx": true,
+	"sy": true, "sz": true, "tc": true, "td": true, "tf": true, "tg": true, "th": true, "tj": true,
+	"tk": true, "tl": true, "tm": true, "tn": true, "to": true, "tr": true, "tt": true, "tv": true,
+	"tw": true, "tz": true, "ua": true, "ug": true, "uk": true, "us": true, "uy": true, "uz": true,
+	"va": true, "vc": true, "ve": true, "vg": true, "vi": true, "vn": true, "vu": true, "wf": true,
+	"ws": true, "ye": true, "yt": true, "za": true, "zm": true, "zw": true,
+
+	// Generic
+	"com": true, "net": true, "org": true, "edu": true, "gov": true, "mil": true, "int": true,
+	"arpa": true, "info": true, "biz": true, "name": true, "pro": true, "aero": true, "asia": true,
+	"cat": true, "coop": true, "jobs": true, "mobi": true, "museum": true, "post": true, "tel": true,
+	"travel": true, "xxx": true, "academy": true, "agency": true, "app": true, "art": true,
+	"bank": true, "bar": true, "best": true, "bid": true, "bio": true, "blog": true, "buzz": true,
+	"cafe": true, "cam": true, "capital": true, "care": true, "cash": true, "center": true,
+	"chat": true, "city": true, "click": true, "cloud": true, "club": true, "codes": true,
+	"company": true, "computer": true, "consulting": true, "digital": true, "direct": true,
+	"download": true, "email": true, "energy": true, "expert": true, "express": true, "fit": true,
+	"fun": true, "fyi": true, "gdn": true, "global": true, "group": true, "guru": true, "host": true,
+	"icu": true, "inc": true, "ink": true, "link": true, "live": true, "loan": true, "ltd": true,
+	"market": true, "media": true, "men": true, "money": true, "network": true, "news": true,
+	"ninja": true, "one": true, "online": true, "page": true, "party": true, "photo": true,
+	"pics": true, "pink": true, "plus": true, "press": true, "racing": true, "red": true,
+	"rest": true, "review": true, "rocks": true, "run": true, "science": true, "services": true,
+	"shop": true, "site": true, "solutions": true, "space": true, "store": true, "stream": true,
+	"studio": true, "support": true, "systems": true, "tech": true, "technology": true, "today": true,
+	"tokyo": true, "top": true, "trade": true, "uno": true, "vip": true, "wang": true,
+	"website": true, "win": true, "work": true, "works": true, "world": true, "wtf": true,
+	"xin": true, "xyz": true, "zone": true,
+}
+
+// Top level domains that are also common file extensions
+var extensionTlds = map[string]bool{
+	"ac": true, "ai": true, "am": true, "as": true, "cc": true, "gd": true, "in": true,
+	"la": true, "md": true, "mk": true, "ml": true, "mm": true, "mo": true, "pf": true,
+	"pl": true, "pm": true, "ps": true, "py": true, "rs": true, "sh": true, "so": true,
+	"tf": true,
+}
@@ -0,0 +1,234 @@
+// Package yara provides a triage processor that scans samples with YARA rules. It lives
+// in its own package because go-yara needs cgo and libyara, which the other processors
+// do not. Importing it registers the processor as "yara".
+package yara
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	goyara "github.com/hillu/go-yara"
+
+	duration "github.com/gdcorp-infosec/threat-util/help/tiduration"
+	"github.com/gdcorp-infosec/threat-util/help/triage"
+	"github.com/gdcorp-infosec/threat-util/help/triage/processors"
+)
+
+// Scan timeout used when neither the processor nor the context sets one
+const DefaultTimeout = time.Minute
+
+var ErrNoRules = errors.New("no yara rules configured")
+
+func init() {
+	triage.MustRegister("yara", triage.StructFactory(func() triage.Processor { return &Yara{} }))
+}
+
+// RuleSource is a rule set given either inline or as a file path
+type RuleSource struct {
+	Namespace string `json:",omitempty"`
+	Rules     string `json:",omitempty"`
+	File      string `json:",omitempty"`
+}
+
+type MatchString struct {
+	Name   string
+	Offset uint64
+	Length int
+}
+
+type Match struct {
+	Rule      string
+	Namespace string
+	Tags      []string               `json:",omitempty"`
+	Meta      map[string]interface{} `json:",omitempty"`
+	Strings   []MatchString          `json:",omitempty"`
+}
+
+type Yara struct {
+	processors.ProcessorBase
+
+	// Options
+	YaraSources []RuleSource `json:",omitempty"`
+	YaraTimeout string       `json:",omitempty"`
+
+	YaraMatches []Match
+}
+
+// Number of compiled rule sets kept. The least recently used one is destroyed once it
+// falls out of the cache and no scan uses it.
+const maxCachedRules = 16
+
+type cachedRules struct {
+	key   string
+	rules *goyara.Rules
+	// Callers that have not released the rules yet
+	users   int
+	evicted bool
+}
+
+var rulesCache = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}{entries: map[string]*list.Element{}, lru: list.New()}
+
+// Returns a key that changes whenever a source or a rule file changes
+func cacheKey(sources []RuleSource) (string, error) {
+	h := sha256.New()
+	for _, source := range sources {
+		fmt.Fprintf(h, "%q %q %q", source.Namespace, source.Rules, source.File)
+		if source.File != "" {
+			fi, err := os.Stat(source.File)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, " %v %v", fi.Size(), fi.ModTime().UnixNano())
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Compile compiles the rule sources, or returns the rules compiled earlier for the same
+// sources. The rules stay valid until release is called.
+func Compile(sources []RuleSource) (rules *goyara.Rules, release func(), err error) {
+	if len(sources) == 0 {
+		return nil, nil, ErrNoRules
+	}
+
+	key, err := cacheKey(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rulesCache.Lock()
+	defer rulesCache.Unlock()
+
+	if e, ok := rulesCache.entries[key]; ok {
+		rulesCache.lru.MoveToFront(e)
+		return acquireRules(e.Value.(*cachedRules))
+	}
+
+	compiler, err := goyara.NewCompiler()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer compiler.Destroy()
+
+	for _, source := range sources {
+		if source.File != "" {
+			err = addFile(compiler, source)
+		} else {
+			err = compiler.AddString(source.Rules, source.Namespace)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rules, err = compiler.GetRules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &cachedRules{key: key, rules: rules}
+	rulesCache.entries[key] = rulesCache.lru.PushFront(c)
+	for rulesCache.lru.Len() > maxCachedRules {
+		evicted := rulesCache.lru.Remove(rulesCache.lru.Back()).(*cachedRules)
+		delete(rulesCache.entries, evicted.key)
+		evicted.evicted = true
+		if evicted.users == 0 {
+			evicted.rules.Destroy()
+		}
+	}
+
+	return acquireRules(c)
+}
+
+// Counts a user of cached rules, with rulesCache locked
+func acquireRules(c *cachedRules) (*goyara.Rules, func(), error) {
+	c.users++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			rulesCache.Lock()
+			defer rulesCache.Unlock()
+
+			c.users--
+			if c.evicted && c.users == 0 {
+				c.rules.Destroy()
+			}
+		})
+	}
+
+	return c.rules, release, nil
+}
+
+func addFile(compiler *goyara.Compiler, source RuleSource) error {
+	f, err := os.Open(source.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return compiler.AddFile(f, source.Namespace)
+}
+
+func (y *Yara) Triage(p []byte) {
+	y.TriageContext(context.Background(), p)
+}
+
+// TriageContext limits the scan to the earlier of the context deadline and YaraTimeout
+func (y *Yara) TriageContext(ctx context.Context, p []byte) {
+	y.AcceptedData = true
+
+	timeout := DefaultTimeout
+	if y.YaraTimeout != "" {
+		t, err := duration.Parse(y.YaraTimeout)
+		if err != nil {
+			y.Error = err
+			return
+		}
+		timeout = t
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	// libyara counts the timeout in whole seconds and treats zero as no timeout
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+
+	rules, release, err := Compile(y.YaraSources)
+	if err != nil {
+		y.Error = err
+		return
+	}
+	defer release()
+
+	matches, err := rules.ScanMem(p, 0, timeout)
+	if err != nil {
+		y.Error = err
+		return
+	}
+
+	y.YaraMatches = make([]Match, 0, len(matches))
+	for _, m := range matches {
+		match := Match{Rule: m.Rule, Namespace: m.Namespace, Tags: m.Tags, Meta: m.Meta}
+		for _, s := range m.Strings {
+			match.Strings = append(match.Strings, MatchString{Name: s.Name, Offset: s.Offset, Length: len(s.Data)})
+		}
+		y.YaraMatches = append(y.YaraMatches, match)
+	}
+}
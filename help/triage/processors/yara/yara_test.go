@@ -0,0 +1,98 @@
+package yara_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdcorp-infosec/threat-util/help/triage"
+	"github.com/gdcorp-infosec/threat-util/help/triage/processors/yara"
+)
+
+const testRules = `
+rule EvilString : suspicious
+{
+	meta:
+		author = "threat"
+	strings:
+		$a = "evil payload"
+	condition:
+		$a
+}
+
+rule NeverMatches
+{
+	condition:
+		false
+}
+`
+
+func TestYara(t *testing.T) {
+	y := &yara.Yara{YaraSources: []yara.RuleSource{{Namespace: "test", Rules: testRules}}}
+	y.Triage([]byte("some data with an evil payload inside"))
+
+	if y.Err() != nil {
+		t.Fatalf("%s", y.Err())
+	} else if len(y.YaraMatches) != 1 {
+		t.Fatalf("bad match count: %v", len(y.YaraMatches))
+	}
+
+	m := y.YaraMatches[0]
+	if m.Rule != "EvilString" || m.Namespace != "test" || len(m.Tags) != 1 || m.Tags[0] != "suspicious" {
+		t.Fatalf("bad match: %+v", m)
+	} else if m.Meta["author"] != "threat" {
+		t.Fatalf("bad meta: %v", m.Meta)
+	} else if len(m.Strings) != 1 || m.Strings[0].Name != "$a" || m.Strings[0].Offset != 18 || m.Strings[0].Length != 12 {
+		t.Fatalf("bad strings: %+v", m.Strings)
+	}
+
+	// Compiled rules are cached
+	r1, release1, err := yara.Compile(y.YaraSources)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	r2, release2, _ := yara.Compile(y.YaraSources)
+	if r1 != r2 {
+		t.Fatalf("rules were not cached")
+	}
+	release2()
+
+	// Up to a limit, after which the rules used least recently are compiled again. Rules
+	// still in use stay valid.
+	for i := 0; i < 16; i++ {
+		_, release, err := yara.Compile([]yara.RuleSource{{Rules: fmt.Sprintf("rule R%v { condition: true }", i)}})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		release()
+	}
+	if matches, err := r1.ScanMem([]byte("an evil payload"), 0, time.Second); err != nil || len(matches) != 1 {
+		t.Fatalf("bad scan with evicted rules: %v %v", matches, err)
+	}
+	release1()
+	r3, release3, _ := yara.Compile(y.YaraSources)
+	if r3 == r1 {
+		t.Fatalf("rules were not evicted")
+	}
+	release3()
+
+	// Compile errors are reported on the processor
+	y = &yara.Yara{YaraSources: []yara.RuleSource{{Rules: "rule Broken { condition: }"}}}
+	y.Triage([]byte("data"))
+	if y.Err() == nil {
+		t.Fatalf("expected compile error")
+	}
+
+	// Registered for profiles
+	profile, err := triage.ParseProfile([]byte(`{"Processors": [{"Name": "yara", "Options": {"YaraSources": [{"Rules": "rule A { strings: $a = \"abc\" condition: $a }"}]}}]}`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	results, err := triage.TriageProfile([]byte("xxabcxx"), profile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if matches := results["yara"].(*yara.Yara).YaraMatches; len(matches) != 1 || !strings.EqualFold(matches[0].Rule, "A") {
+		t.Fatalf("bad profile result")
+	}
+}
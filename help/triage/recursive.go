@@ -0,0 +1,108 @@
+package triage
+
+import (
+	"errors"
+
+	"github.com/gdcorp-infosec/threat-util/help/archive"
+)
+
+var ErrMaxDepth = errors.New("maximum archive depth reached")
+
+// Node is the result of triaging a sample and, when it is an archive, its children
+type Node struct {
+	// Path inside the parent archive, empty for the root
+	Path        string  `json:",omitempty"`
+	Results     Results `json:",omitempty"`
+	ArchiveType string  `json:",omitempty"`
+	Encrypted   bool    `json:",omitempty"`
+	// Why the sample or its children were not fully triaged
+	Error    string  `json:",omitempty"`
+	Children []*Node `json:",omitempty"`
+}
+
+// TriageRecursive triages data with profile and, when data is a zip, tar, gzip or bzip2
+// archive, triages each extracted child in turn. The limits apply to the whole tree.
+// Processor errors from every node are returned together as Errors.
+func TriageRecursive(data []byte, profile *Profile, limits archive.Limits) (*Node, error) {
+	if profile == nil {
+		profile = DefaultProfile
+	}
+
+	// Checks the profile once up front rather than at every node
+	if _, err := profile.New(); err != nil {
+		return nil, err
+	}
+
+	root := &Node{}
+	var errs Errors
+	triageNode(root, data, "", profile, archive.NewExtractor(limits), 0, &errs)
+
+	if len(errs) > 0 {
+		return root, errs
+	}
+
+	return root, nil
+}
+
+// FileNameSetter is implemented by processors that check a sample against its file name.
+// TriageRecursive gives them the name of each child.
+type FileNameSetter interface {
+	SetFileName(string)
+}
+
+func triageNode(node *Node, data []byte, fullPath string, profile *Profile, extractor *archive.Extractor, depth int, errs *Errors) {
+	results, err := profile.New()
+	if err == nil {
+		if node.Path != "" {
+			for _, p := range results {
+				if s, ok := p.(FileNameSetter); ok {
+					s.SetFileName(node.Path)
+				}
+			}
+		}
+		err = TriageWithProcessors(data, profile.list(results))
+	}
+	node.Results = results
+
+	var processorErrs Errors
+	if errors.As(err, &processorErrs) {
+		for _, pe := range processorErrs {
+			pe.Path = fullPath
+			*errs = append(*errs, pe)
+		}
+	}
+
+	node.ArchiveType = archive.Type(data)
+	if node.ArchiveType == "" {
+		return
+	}
+
+	if extractor.Limits.MaxDepth > 0 && depth >= extractor.Limits.MaxDepth {
+		node.Error = ErrMaxDepth.Error()
+		return
+	}
+
+	entries, err := extractor.Extract(data)
+	if err != nil {
+		node.Error = err.Error()
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		child := &Node{Path: entry.Path, Encrypted: entry.Encrypted}
+
+		if entry.Err != nil {
+			child.Error = entry.Err.Error()
+		} else {
+			childPath := entry.Path
+			if fullPath != "" {
+				childPath = fullPath + "/" + entry.Path
+			}
+			triageNode(child, entry.Data, childPath, profile, extractor, depth+1, errs)
+		}
+
+		// Release each child once triaged
+		entry.Data = nil
+		node.Children = append(node.Children, child)
+	}
+}
@@ -0,0 +1,257 @@
+package triage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+
+	duration "github.com/gdcorp-infosec/threat-util/help/tiduration"
+	. "github.com/gdcorp-infosec/threat-util/help/triage/processors"
+	"sigs.k8s.io/yaml"
+)
+
+// Factory creates a new processor configured with options, which may be empty
+type Factory func(options json.RawMessage) (Processor, error)
+
+type registry struct {
+	sync.RWMutex
+
+	factories map[string]Factory
+	names     map[reflect.Type]string
+}
+
+var processorRegistry = &registry{factories: map[string]Factory{}, names: map[reflect.Type]string{}}
+
+var ErrUnknownProcessor = errors.New("unknown processor")
+
+func init() {
+	MustRegister("hashes", StructFactory(func() Processor { return &Hashes{} }))
+	MustRegister("size", StructFactory(func() Processor { return &Size{} }))
+	MustRegister("entropy", StructFactory(func() Processor { return &ShannonEntropy{} }))
+	MustRegister("filetype", StructFactory(func() Processor { return &FileType{} }))
+	MustRegister("bytedistribution", StructFactory(func() Processor { return &ByteDistribution{} }))
+	MustRegister("fuzzyhash", StructFactory(func() Processor { return &FuzzyHash{} }))
+	MustRegister("time", StructFactory(func() Processor { return &Time{} }))
+	MustRegister("strings", StructFactory(func() Processor { return &Strings{} }))
+	MustRegister("indicators", StructFactory(func() Processor { return &Indicators{} }))
+	MustRegister("pe", StructFactory(func() Processor { return &Pe{} }))
+	MustRegister("elf", StructFactory(func() Processor { return &Elf{} }))
+	MustRegister("macros", StructFactory(func() Processor { return &Macros{} }))
+	MustRegister("pdf", StructFactory(func() Processor { return &Pdf{} }))
+	MustRegister("macho", StructFactory(func() Processor { return &Macho{} }))
+	MustRegister("carve", StructFactory(func() Processor { return &Carve{} }))
+	MustRegister("mismatch", StructFactory(func() Processor { return &Mismatch{} }))
+}
+
+// StructFactory returns a Factory that creates processors with newProcessor and decodes
+// the options as JSON into them.
+func StructFactory(newProcessor func() Processor) Factory {
+	return func(options json.RawMessage) (Processor, error) {
+		p := newProcessor()
+		if len(options) > 0 {
+			if err := json.Unmarshal(options, p); err != nil {
+				return nil, err
+			}
+		}
+
+		return p, nil
+	}
+}
+
+// Register adds a processor under a stable name. Registering the same name twice is an error.
+func Register(name string, factory Factory) error {
+	p, err := factory(nil)
+	if err != nil {
+		return err
+	}
+
+	processorRegistry.Lock()
+	defer processorRegistry.Unlock()
+
+	if _, ok := processorRegistry.factories[name]; ok {
+		return fmt.Errorf("processor %q is already registered", name)
+	}
+
+	processorRegistry.factories[name] = factory
+	processorRegistry.names[reflect.TypeOf(p)] = name
+
+	return nil
+}
+
+func MustRegister(name string, factory Factory) {
+	if err := Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// New creates the processor registered under name
+func New(name string, options json.RawMessage) (Processor, error) {
+	processorRegistry.RLock()
+	factory, ok := processorRegistry.factories[name]
+	processorRegistry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProcessor, name)
+	}
+
+	return factory(options)
+}
+
+// Names returns the names of all registered processors in sorted order
+func Names() []string {
+	processorRegistry.RLock()
+	defer processorRegistry.RUnlock()
+
+	var result []string
+	for name := range processorRegistry.factories {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Returns the registered name of a processor, if any
+func registeredName(p Processor) (string, bool) {
+	processorRegistry.RLock()
+	defer processorRegistry.RUnlock()
+
+	name, ok := processorRegistry.names[reflect.TypeOf(p)]
+	return name, ok
+}
+
+type ProfileEntry struct {
+	Name    string
+	Options json.RawMessage `json:",omitempty"`
+}
+
+// Profile selects the processors to run and their options. Workers and Timeout are
+// only used by TriageProfileContext.
+type Profile struct {
+	Processors []ProfileEntry
+	Workers    int    `json:",omitempty"`
+	Timeout    string `json:",omitempty"`
+}
+
+var DefaultProfile = &Profile{
+	Processors: []ProfileEntry{
+		{Name: "hashes"},
+		{Name: "size"},
+		{Name: "entropy"},
+		{Name: "filetype"},
+		{Name: "bytedistribution"},
+		{Name: "fuzzyhash"},
+		{Name: "time"},
+	},
+}
+
+// ParseProfile decodes a JSON or YAML profile and checks that every processor exists
+func ParseProfile(data []byte) (*Profile, error) {
+	if !json.Valid(data) {
+		// YAML is converted to JSON first so that processor options are kept as JSON
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		data = converted
+	}
+
+	profile := &Profile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, err
+	}
+
+	if _, err := profile.New(); err != nil {
+		return nil, err
+	}
+
+	if _, err := profile.ConcurrentOptions(); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// Results maps the name of each processor in a profile to its result
+type Results map[string]Processor
+
+// New creates the processors of the profile
+func (profile *Profile) New() (Results, error) {
+	results := Results{}
+	for _, entry := range profile.Processors {
+		if _, ok := results[entry.Name]; ok {
+			return nil, fmt.Errorf("processor %q is listed twice", entry.Name)
+		}
+
+		p, err := New(entry.Name, entry.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		results[entry.Name] = p
+	}
+
+	return results, nil
+}
+
+func (profile *Profile) ConcurrentOptions() (ConcurrentOptions, error) {
+	options := ConcurrentOptions{Workers: profile.Workers}
+	if profile.Timeout != "" {
+		timeout, err := duration.Parse(profile.Timeout)
+		if err != nil {
+			return options, err
+		}
+		options.Timeout = timeout
+	}
+
+	return options, nil
+}
+
+// Returns the processors in profile order
+func (profile *Profile) list(results Results) []Processor {
+	processors := make([]Processor, 0, len(profile.Processors))
+	for _, entry := range profile.Processors {
+		processors = append(processors, results[entry.Name])
+	}
+
+	return processors
+}
+
+// TriageProfile runs the processors selected by profile. Results are returned along with
+// any error so that failing processors do not hide the others.
+func TriageProfile(data []byte, profile *Profile) (Results, error) {
+	results, err := profile.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, TriageWithProcessors(data, profile.list(results))
+}
+
+func TriageProfileReader(r io.Reader, profile *Profile) (Results, error) {
+	results, err := profile.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, TriageReaderWithProcessors(r, profile.list(results))
+}
+
+func TriageProfileContext(ctx context.Context, data []byte, profile *Profile) (Results, error) {
+	results, err := profile.New()
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := profile.ConcurrentOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, TriageWithProcessorsContext(ctx, data, profile.list(results), options)
+}
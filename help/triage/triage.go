@@ -1,6 +1,9 @@
 package triage
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
 
 	. "github.com/gdcorp-infosec/threat-util/help/triage/processors"
@@ -12,6 +15,20 @@ type Processor interface {
 	Err() error
 }
 
+// StreamProcessor is implemented by processors that can consume a sample in chunks,
+// keeping a bounded amount of state regardless of the sample size.
+type StreamProcessor interface {
+	Processor
+	Begin()
+	Update([]byte)
+	End()
+}
+
+// Size of the chunks read by the streaming triage functions
+const ChunkSize = 64 * 1024
+
+var ErrNotStreamProcessor = errors.New("processor does not support streaming")
+
 type DefaultProcessors struct {
 	Hashes
 	Size
@@ -27,21 +44,55 @@ func (dp *DefaultProcessors) Triage(data []byte) error {
 	return TriageWithProcessors(data, []Processor{&dp.Hashes, &dp.Size, &dp.ShannonEntropy, &dp.FileType, &dp.ByteDistribution, &dp.FuzzyHash, &dp.Time})
 }
 
+func (dp *DefaultProcessors) TriageReader(r io.Reader) error {
+	*dp = DefaultProcessors{}
+	return TriageReaderWithProcessors(r, []Processor{&dp.Hashes, &dp.Size, &dp.ShannonEntropy, &dp.FileType, &dp.ByteDistribution, &dp.FuzzyHash, &dp.Time})
+}
+
 func TriageWithProcessors(data []byte, processors []Processor) error {
 	for _, p := range processors {
 		p.Triage(data)
 	}
 
+	return checkErrors(processors)
+}
+
+// TriageReaderWithProcessors reads r once in chunks of ChunkSize and passes each chunk
+// to every processor. All processors must implement StreamProcessor.
+func TriageReaderWithProcessors(r io.Reader, processors []Processor) error {
+	streamProcessors := make([]StreamProcessor, 0, len(processors))
 	for _, p := range processors {
-		if p.HasAcceptedData() {
-			err := p.Err()
-			if err != nil {
-				return err
+		sp, ok := p.(StreamProcessor)
+		if !ok {
+			return ErrNotStreamProcessor
+		}
+		streamProcessors = append(streamProcessors, sp)
+	}
+
+	for _, sp := range streamProcessors {
+		sp.Begin()
+	}
+
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			for _, sp := range streamProcessors {
+				sp.Update(buf[:n])
 			}
 		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	for _, sp := range streamProcessors {
+		sp.End()
+	}
+
+	return checkErrors(processors)
 }
 
 func Triage(data []byte) (*DefaultProcessors, error) {
@@ -54,15 +105,78 @@ func Triage(data []byte) (*DefaultProcessors, error) {
 	return dp, nil
 }
 
+// TriagePartial returns the results of every processor even when some of them failed.
+// The error, if any, is of type Errors.
+func TriagePartial(data []byte) (*DefaultProcessors, error) {
+	dp := &DefaultProcessors{}
+	err := dp.Triage(data)
+
+	return dp, err
+}
+
+func TriageReader(r io.Reader) (*DefaultProcessors, error) {
+	dp := &DefaultProcessors{}
+	err := dp.TriageReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return dp, nil
+}
+
+// TriageFields runs every processor held in the fields of v, which should be a pointer to
+// a struct. Fields may hold processors by value or by pointer. Nil pointer fields tagged
+// with `triage:"name"` are first set to a new processor from the registry.
 func TriageFields(data []byte, v interface{}) error {
+	processors, err := fieldProcessors(v)
+	if err != nil {
+		return err
+	}
+
+	return TriageWithProcessors(data, processors)
+}
+
+func fieldProcessors(v interface{}) ([]Processor, error) {
 	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, errors.New("triage fields require a struct")
+	}
 
 	var processors []Processor
 	for i := 0; i < value.NumField(); i++ {
-		if processor, ok := value.Field(i).Interface().(Processor); ok {
+		field := value.Field(i)
+		if !field.CanInterface() {
+			continue
+		}
+
+		if name, ok := value.Type().Field(i).Tag.Lookup("triage"); ok && field.Kind() == reflect.Ptr && field.IsNil() && field.CanSet() {
+			p, err := New(name, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			pv := reflect.ValueOf(p)
+			if !pv.Type().AssignableTo(field.Type()) {
+				return nil, fmt.Errorf("processor %q cannot be assigned to field %s", name, value.Type().Field(i).Name)
+			}
+			field.Set(pv)
+		}
+
+		if field.Kind() == reflect.Ptr && field.IsNil() {
+			continue
+		}
+
+		if processor, ok := field.Interface().(Processor); ok {
 			processors = append(processors, processor)
+		} else if field.CanAddr() {
+			if processor, ok := field.Addr().Interface().(Processor); ok {
+				processors = append(processors, processor)
+			}
 		}
 	}
 
-	return TriageWithProcessors(data, processors)
+	return processors, nil
 }
@@ -1,13 +1,25 @@
 package triage_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/gdcorp-infosec/threat-util/help/archive"
+	"github.com/gdcorp-infosec/threat-util/help/filetype"
 	"github.com/gdcorp-infosec/threat-util/help/triage"
+	"github.com/gdcorp-infosec/threat-util/help/triage/processors"
 )
 
 var triageTestDataHex string = `
@@ -84,34 +96,42 @@ var triageTestDataResult = `{
   "Size": 1024,
   "ShannonEntropy": 1.973113266796047,
   "FileType": {
-    "FileTypeId": 5,
+    "FileTypeId": "pe",
     "Description": "PE Executable",
     "Extensions": [
       "exe"
-    ]
+    ],
+    "MimeType": "application/vnd.microsoft.portable-executable",
+    "Confidence": 0.95
   },
   "FileTypes": [
     {
-      "FileTypeId": 5,
+      "FileTypeId": "pe",
       "Description": "PE Executable",
       "Extensions": [
         "exe"
-      ]
+      ],
+      "MimeType": "application/vnd.microsoft.portable-executable",
+      "Confidence": 0.95
     },
     {
-      "FileTypeId": 4,
+      "FileTypeId": "dos",
       "Description": "DOS Executable",
       "Extensions": [
         "exe"
-      ]
+      ],
+      "MimeType": "application/x-dosexec",
+      "Confidence": 0.6
     },
     {
-      "FileTypeId": 2,
+      "FileTypeId": "binary",
       "Description": "Binary",
       "Extensions": [
         "dat",
         "bin"
-      ]
+      ],
+      "MimeType": "application/octet-stream",
+      "Confidence": 0.1
     }
   ],
   "ByteDistribution": {
@@ -401,3 +421,437 @@ func TestTriage(t *testing.T) {
 		t.Fatalf("bad result")
 	}
 }
+
+func TestTriageReader(t *testing.T) {
+	data, err := hex.DecodeString(strings.Replace(triageTestDataHex, "\n", "", -1))
+	if err != nil {
+		t.Fatal("bad hex data")
+	}
+
+	dp, err := triage.TriageReader(iotest.HalfReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dp.Time.Time = time.Time{}
+
+	jsonBytes, err := json.MarshalIndent(dp, "", "  ")
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if string(jsonBytes) != triageTestDataResult {
+		t.Fatalf("bad result")
+	}
+}
+
+type slowProcessor struct {
+	processors.ProcessorBase
+
+	Delay time.Duration
+	Done  bool
+}
+
+func (sp *slowProcessor) Triage(p []byte) {
+	time.Sleep(sp.Delay)
+	sp.Done = true
+	sp.AcceptedData = true
+}
+
+// Records the most processors sharing its counters that ran at once
+type concurrencyProcessor struct {
+	processors.ProcessorBase
+
+	Delay   time.Duration
+	running *int32
+	max     *int32
+}
+
+func (cp *concurrencyProcessor) Triage(p []byte) {
+	n := atomic.AddInt32(cp.running, 1)
+	for m := atomic.LoadInt32(cp.max); n > m && !atomic.CompareAndSwapInt32(cp.max, m, n); m = atomic.LoadInt32(cp.max) {
+	}
+
+	time.Sleep(cp.Delay)
+	atomic.AddInt32(cp.running, -1)
+	cp.AcceptedData = true
+}
+
+type hungProcessor struct {
+	processors.ProcessorBase
+
+	Hang chan struct{}
+}
+
+func (hp *hungProcessor) Triage(p []byte) {
+	<-hp.Hang
+}
+
+func TestTriageContext(t *testing.T) {
+	data, err := hex.DecodeString(strings.Replace(triageTestDataHex, "\n", "", -1))
+	if err != nil {
+		t.Fatal("bad hex data")
+	}
+
+	dp := &triage.DefaultProcessors{}
+	err = dp.TriageContext(context.Background(), data, triage.ConcurrentOptions{Workers: 2})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dp.Time.Time = time.Time{}
+
+	jsonBytes, err := json.MarshalIndent(dp, "", "  ")
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if string(jsonBytes) != triageTestDataResult {
+		t.Fatalf("bad result")
+	}
+
+	// Timeouts are reported per processor without losing the others
+	fast := &slowProcessor{}
+	slow := &slowProcessor{Delay: time.Second}
+	size := &processors.Size{}
+	err = triage.TriageWithProcessorsContext(context.Background(), data, []triage.Processor{fast, slow, size}, triage.ConcurrentOptions{Workers: 1, Timeout: 50 * time.Millisecond})
+
+	var timeoutErr *triage.TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	} else if !fast.Done || fast.Err() != nil {
+		t.Fatalf("fast processor did not finish")
+	} else if slow.Done || !errors.As(slow.Err(), &timeoutErr) {
+		t.Fatalf("slow processor did not time out")
+	} else if size.Size != len(data) {
+		t.Fatalf("bad size")
+	}
+
+	// Abandoned processors give up their slot, but no more are started once too many of them
+	// are still running
+	var running, max int32
+	var concurrent []triage.Processor
+	for i := 0; i < 4; i++ {
+		concurrent = append(concurrent, &concurrencyProcessor{Delay: 200 * time.Millisecond, running: &running, max: &max})
+	}
+	err = triage.TriageWithProcessorsContext(context.Background(), data, concurrent, triage.ConcurrentOptions{Workers: 1, Timeout: 10 * time.Millisecond, MaxAbandoned: 2})
+	if !errors.Is(err, triage.ErrTooManyAbandoned) || !errors.Is(concurrent[3].Err(), triage.ErrTooManyAbandoned) {
+		t.Fatalf("expected too many abandoned processors, got %v", err)
+	} else if !errors.Is(concurrent[1].Err(), context.DeadlineExceeded) || concurrent[2].Err() == nil {
+		t.Fatalf("bad abandoned processor errors %v", err)
+	} else if atomic.LoadInt32(&max) > 2 {
+		t.Fatalf("bad concurrency %v", max)
+	}
+
+	// Processors that never return do not block a context without a deadline
+	hang := make(chan struct{})
+	defer close(hang)
+	hung := []triage.Processor{&hungProcessor{Hang: hang}, &hungProcessor{Hang: hang}, &hungProcessor{Hang: hang}}
+	err = triage.TriageWithProcessorsContext(context.Background(), data, hung, triage.ConcurrentOptions{Workers: 1, Timeout: 10 * time.Millisecond, MaxAbandoned: 1})
+	if !errors.Is(hung[0].Err(), context.DeadlineExceeded) || !errors.Is(hung[2].Err(), triage.ErrTooManyAbandoned) {
+		t.Fatalf("bad hung processors %v", err)
+	}
+
+	// Processors that never started are marked when the context ends
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	size = &processors.Size{}
+	err = triage.TriageWithProcessorsContext(ctx, data, []triage.Processor{size}, triage.ConcurrentOptions{})
+	if !errors.Is(err, context.Canceled) || !errors.Is(size.Err(), context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+type failingProcessor struct {
+	processors.ProcessorBase
+}
+
+var errFailing = errors.New("failing processor")
+
+func (fp *failingProcessor) Triage(p []byte) {
+	fp.Error = errFailing
+	fp.AcceptedData = true
+}
+
+func TestTriageErrors(t *testing.T) {
+	size := &processors.Size{}
+	err := triage.TriageWithProcessors([]byte("abc"), []triage.Processor{&failingProcessor{}, size, &failingProcessor{}})
+
+	var errs triage.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", err)
+	} else if errs[0].Processor != "failingProcessor" || errs[0].Err != errFailing {
+		t.Fatalf("bad processor error")
+	} else if !errors.Is(err, errFailing) {
+		t.Fatalf("errors.Is failed")
+	} else if size.Size != 3 {
+		t.Fatalf("bad size")
+	}
+
+	dp, err := triage.TriagePartial([]byte("abc"))
+	if err != nil || dp == nil || dp.Size.Size != 3 {
+		t.Fatalf("bad partial result")
+	}
+}
+
+func TestTriageProfile(t *testing.T) {
+	profile, err := triage.ParseProfile([]byte(`{"Processors": [{"Name": "size"}, {"Name": "hashes"}], "Timeout": "1m"}`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	results, err := triage.TriageProfile([]byte("abc"), profile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if len(results) != 2 || results["size"].(*processors.Size).Size != 3 {
+		t.Fatalf("bad profile result")
+	} else if results["hashes"].(*processors.Hashes).Md5HexDigest != "900150983CD24FB0D6963F7D28E17F72" {
+		t.Fatalf("bad hashes")
+	}
+
+	// YAML profiles give options the same way
+	profile, err = triage.ParseProfile([]byte("processors:\n- name: size\n- name: strings\n  options:\n    StringsMinLength: 8\n" +
+		"workers: 2\ntimeout: 30s\n"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	results, err = triage.TriageProfile([]byte("short\x00a longer string\x00"), profile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if s := results["strings"].(*processors.Strings); len(s.Strings) != 1 || s.Strings[0].Value != "a longer string" {
+		t.Fatalf("bad strings %+v", s.Strings)
+	} else if options, err := profile.ConcurrentOptions(); err != nil || options.Workers != 2 || options.Timeout != 30*time.Second {
+		t.Fatalf("bad options %+v %v", options, err)
+	}
+
+	if _, err := triage.ParseProfile([]byte("processors: [name: size")); err == nil {
+		t.Fatal("expected YAML error")
+	}
+
+	if _, err := triage.ParseProfile([]byte(`{"Processors": [{"Name": "nope"}]}`)); !errors.Is(err, triage.ErrUnknownProcessor) {
+		t.Fatalf("expected unknown processor error, got %v", err)
+	}
+
+	if err := triage.Register("size", triage.StructFactory(func() triage.Processor { return &processors.Size{} })); err == nil {
+		t.Fatalf("expected duplicate registration error")
+	}
+}
+
+func TestTriageFields(t *testing.T) {
+	data, err := hex.DecodeString(strings.Replace(triageTestDataHex, "\n", "", -1))
+	if err != nil {
+		t.Fatal("bad hex data")
+	}
+
+	dp := &triage.DefaultProcessors{}
+	if err := triage.TriageFields(data, dp); err != nil {
+		t.Fatalf("%s", err)
+	}
+	dp.Time.Time = time.Time{}
+
+	jsonBytes, err := json.MarshalIndent(dp, "", "  ")
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if string(jsonBytes) != triageTestDataResult {
+		t.Fatalf("bad result")
+	}
+
+	fields := &struct {
+		Size    *processors.Size `triage:"size"`
+		Entropy *processors.ShannonEntropy
+	}{}
+	if err := triage.TriageFields(data, fields); err != nil {
+		t.Fatalf("%s", err)
+	} else if fields.Size == nil || fields.Size.Size != len(data) || fields.Entropy != nil {
+		t.Fatalf("bad tagged fields")
+	}
+}
+
+func TestStrings(t *testing.T) {
+	data := []byte("\x00\x01hello world\x00ab\x00\xffh\x00e\x00l\x00l\x00o\x00\x00\x00hello again\x01")
+
+	s := &processors.Strings{}
+	s.Triage(data)
+
+	expected := []processors.ExtractedString{
+		{Offset: 2, Encoding: processors.StringEncodingAscii, Value: "hello world"},
+		{Offset: 18, Encoding: processors.StringEncodingUtf16Le, Value: "hello"},
+		{Offset: 30, Encoding: processors.StringEncodingAscii, Value: "hello again"},
+	}
+	if !reflect.DeepEqual(s.Strings, expected) || s.StringsTruncated {
+		t.Fatalf("bad strings: %v", s.Strings)
+	} else if !reflect.DeepEqual(s.StringsTopTokens, []string{"hello"}) {
+		t.Fatalf("bad top tokens: %v", s.StringsTopTokens)
+	}
+
+	// Ties are ordered by token whatever the map order
+	for i := 0; i < 10; i++ {
+		s = &processors.Strings{StringsMaxTokens: 3}
+		s.Triage([]byte("delta\x00Alpha\x00charlie\x00bravo\x00alpha\x00ALPHA\x00charlie\x00bravo\x00delta\x00echo echo echo"))
+		if !reflect.DeepEqual(s.StringsTopTokens, []string{"ALPHA", "echo", "bravo"}) {
+			t.Fatalf("bad top token order: %v", s.StringsTopTokens)
+		}
+	}
+
+	s = &processors.Strings{StringsMinLength: 2, StringsMaxCount: 2}
+	s.Triage(data)
+	if len(s.Strings) != 2 || !s.StringsTruncated || s.Strings[1].Value != "ab" {
+		t.Fatalf("bad capped strings: %v", s.Strings)
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	text := "visit hxxps://evil[.]example[.]com/payload.exe, mail admin@example.org or " +
+		"https://evil.example.com/payload.exe. c2 10.1.2.3 and fe80::1ff:fe23:4567:890a, " +
+		"std::cout 12:30:45 kernel32.dll d41d8cd98f00b204e9800998ecf8427e " +
+		"da39a3ee5e6b4b0d3255bfef95601890afd80709 " +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 " +
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2 bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3 " +
+		"install.sh libc.so.6 main.py readme.md cdn.example.sh ftp://files.sh/a ::1 [::ffff:10.0.0.1] " +
+		"x :: Int, Foo::Bar, std :: string"
+
+	// UTF-16LE copy of an extra domain
+	var data []byte
+	data = append(data, text...)
+	data = append(data, 0x00, 0xFF)
+	for _, c := range "update.badhost.ru" {
+		data = append(data, byte(c), 0x00)
+	}
+
+	ind := &processors.Indicators{}
+	ind.Triage(data)
+
+	expectedUrls := []processors.Indicator{{Value: "https://evil.example.com/payload.exe", Count: 2}, {Value: "ftp://files.sh/a", Count: 1}}
+	expectedDomains := []processors.Indicator{{Value: "evil.example.com", Count: 2}, {Value: "cdn.example.sh", Count: 1},
+		{Value: "example.org", Count: 1}, {Value: "files.sh", Count: 1}, {Value: "update.badhost.ru", Count: 1}}
+	expectedIpv6 := []processors.Indicator{{Value: "::1", Count: 1}, {Value: "::ffff:10.0.0.1", Count: 1}, {Value: "fe80::1ff:fe23:4567:890a", Count: 1}}
+	if !reflect.DeepEqual(ind.Urls, expectedUrls) {
+		t.Fatalf("bad urls: %v", ind.Urls)
+	} else if !reflect.DeepEqual(ind.Domains, expectedDomains) {
+		t.Fatalf("bad domains: %v", ind.Domains)
+	} else if !reflect.DeepEqual(ind.EmailAddresses, []processors.Indicator{{Value: "admin@example.org", Count: 1}}) {
+		t.Fatalf("bad emails: %v", ind.EmailAddresses)
+	} else if !reflect.DeepEqual(ind.Ipv4Addresses, []processors.Indicator{{Value: "10.0.0.1", Count: 1}, {Value: "10.1.2.3", Count: 1}}) {
+		t.Fatalf("bad ipv4: %v", ind.Ipv4Addresses)
+	} else if !reflect.DeepEqual(ind.Ipv6Addresses, expectedIpv6) {
+		t.Fatalf("bad ipv6: %v", ind.Ipv6Addresses)
+	} else if len(ind.Md5Hashes) != 1 || len(ind.Sha1Hashes) != 1 || len(ind.Sha256Hashes) != 1 {
+		t.Fatalf("bad hashes")
+	} else if len(ind.BitcoinAddresses) != 2 {
+		t.Fatalf("bad bitcoin addresses: %v", ind.BitcoinAddresses)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	project, err := ioutil.ReadFile("../vba/testdata/vbaProject.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// A macro-enabled OOXML document keeps the project in a zip part
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("[Content_Types].xml")
+	w.Write([]byte("<Types/>"))
+	w, _ = zw.Create("word/vbaProject.bin")
+	w.Write(project)
+	zw.Close()
+
+	for _, data := range [][]byte{project, buf.Bytes()} {
+		m := &processors.Macros{}
+		m.Triage(data)
+
+		if m.Err() != nil || !m.HasAcceptedData() {
+			t.Fatalf("bad result: %v", m.Err())
+		} else if len(m.VbaProjects) != 1 || len(m.VbaProjects[0].Modules) != 2 {
+			t.Fatalf("bad projects: %+v", m.VbaProjects)
+		} else if !reflect.DeepEqual(m.VbaAutoExec, []string{"AutoOpen", "Document_Open"}) {
+			t.Fatalf("bad auto-exec: %v", m.VbaAutoExec)
+		} else if !reflect.DeepEqual(m.VbaSuspicious, []string{"CreateObject", "Shell", "WScript.Shell", "cmd.exe"}) {
+			t.Fatalf("bad suspicious keywords: %v", m.VbaSuspicious)
+		}
+	}
+
+	m := &processors.Macros{}
+	m.Triage(buf.Bytes())
+	if stream := m.VbaProjects[0].Modules[1].Stream; stream != "word/vbaProject.bin/VBA/Module1" {
+		t.Fatalf("bad stream path: %v", stream)
+	}
+
+	m = &processors.Macros{}
+	m.Triage([]byte("plain text"))
+	if m.HasAcceptedData() || m.VbaProjects != nil {
+		t.Fatal("bad plain text result")
+	}
+}
+
+func TestTriageRecursive(t *testing.T) {
+	// A zip holding a text file, an encrypted entry and a gzip of another text file
+	inner := &bytes.Buffer{}
+	gw := gzip.NewWriter(inner)
+	gw.Name = "inner.txt"
+	gw.Write([]byte("inner text file"))
+	gw.Close()
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("docs/readme.txt")
+	w.Write([]byte("hello"))
+	w, _ = zw.CreateHeader(&zip.FileHeader{Name: "secret.bin", Method: zip.Deflate, Flags: 0x1})
+	w.Write([]byte("not really encrypted"))
+	w, _ = zw.Create("nested/inner.txt.gz")
+	w.Write(inner.Bytes())
+	w, _ = zw.Create("bomb.txt")
+	w.Write(bytes.Repeat([]byte{'A'}, 1<<20))
+	zw.Close()
+
+	profile := &triage.Profile{Processors: []triage.ProfileEntry{{Name: "size"}}}
+	root, err := triage.TriageRecursive(buf.Bytes(), profile, archive.DefaultLimits)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	size := func(n *triage.Node) int { return n.Results["size"].(*processors.Size).Size }
+
+	if root.ArchiveType != archive.TypeZip || len(root.Children) != 4 {
+		t.Fatalf("bad root: %+v", root)
+	} else if c := root.Children[0]; c.Path != "docs/readme.txt" || size(c) != 5 {
+		t.Fatalf("bad child: %+v", c)
+	} else if c := root.Children[1]; !c.Encrypted || c.Results != nil {
+		t.Fatalf("encrypted entry not reported: %+v", c)
+	} else if c := root.Children[2]; c.ArchiveType != archive.TypeGzip || len(c.Children) != 1 || c.Children[0].Path != "inner.txt" || size(c.Children[0]) != 15 {
+		t.Fatalf("bad nested archive: %+v", c)
+	} else if c := root.Children[3]; c.Error != archive.ErrCompressionRatio.Error() {
+		t.Fatalf("zip bomb not detected: %+v", c)
+	}
+
+	// Depth and file count limits
+	root, err = triage.TriageRecursive(buf.Bytes(), profile, archive.Limits{MaxDepth: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("%s", err)
+	} else if root.Error != archive.ErrFileCountLimit.Error() || len(root.Children) != 3 {
+		t.Fatalf("file count limit not applied: %+v", root)
+	} else if c := root.Children[2]; c.Error != triage.ErrMaxDepth.Error() || c.Children != nil {
+		t.Fatalf("depth limit not applied: %+v", c)
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("invoice.pdf.exe")
+	w.Write([]byte("MZ"))
+	w, _ = zw.Create("docs/report.pdf")
+	w.Write([]byte("MZ"))
+	zw.Close()
+
+	profile := &triage.Profile{Processors: []triage.ProfileEntry{{Name: "mismatch"}}}
+	root, err := triage.TriageRecursive(buf.Bytes(), profile, archive.DefaultLimits)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	mismatch := func(n *triage.Node) *filetype.Mismatch { return n.Results["mismatch"].(*processors.Mismatch).Mismatch }
+
+	if m := mismatch(root); m.FileName != "" || m.Suspicious() {
+		t.Fatalf("bad root: %+v", m)
+	} else if m := mismatch(root.Children[0]); m.FileName != "invoice.pdf.exe" || !m.DoubleExtension {
+		t.Fatalf("double extension not found: %+v", m)
+	} else if m := mismatch(root.Children[1]); m.FileName != "docs/report.pdf" || !m.ExtensionMismatch {
+		t.Fatalf("extension mismatch not found: %+v", m)
+	}
+}
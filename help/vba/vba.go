@@ -0,0 +1,256 @@
+// Package vba extracts VBA macro source code from the projects stored in Office documents
+package vba
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gdcorp-infosec/threat-util/help/binary"
+	"github.com/gdcorp-infosec/threat-util/help/ole"
+)
+
+var (
+	ErrNoProject  = errors.New("no VBA project found")
+	ErrCompressed = errors.New("invalid compressed VBA container")
+)
+
+// Upper bound on the size of decompressed data, to keep malformed containers from
+// exhausting memory
+const MaxDecompressedSize = 64 * 1024 * 1024
+
+const (
+	recordProjectVersion   = 0x0009
+	recordModuleName       = 0x0019
+	recordModuleStreamName = 0x001A
+	recordModuleOffset     = 0x0031
+	recordModuleTerminator = 0x002B
+)
+
+type Module struct {
+	Name   string
+	Stream string
+	Code   string
+	// Auto-exec entry points and suspicious keywords found in the code
+	AutoExec   []string `json:",omitempty"`
+	Suspicious []string `json:",omitempty"`
+	Err        error    `json:",omitempty"`
+}
+
+type Project struct {
+	// Path of the VBA storage within the compound file
+	Path    string
+	Modules []Module
+}
+
+// Procedures that Office runs without user interaction
+var autoExecRegexp = regexp.MustCompile(`(?i)\b(?:Auto_?Open|Auto_?Close|Auto_?Exec|Auto_?Exit|Auto_?New|` +
+	`Document_?Open|Document_?Close|Document_?New|Document_BeforeClose|DocumentChange|` +
+	`Workbook_?Open|Workbook_Activate|Workbook_Close|Workbook_BeforeClose|` +
+	`\w+_Painted|\w+_GotFocus|\w+_Layout)\b`)
+
+// Keywords that macros use to run commands, download files or call native code
+var suspiciousRegexp = regexp.MustCompile(`(?i)\b(?:Shell|ShellExecute|WScript\.Shell|Shell\.Application|` +
+	`CreateObject|GetObject|CallByName|MacScript|SendKeys|ExecuteExcel4Macro|Environ|Kill|` +
+	`URLDownloadToFileA?|XMLHTTP|ServerXMLHTTP|WinHttpRequest|ADODB\.Stream|SaveToFile|` +
+	`VirtualAlloc|RtlMoveMemory|CreateThread|WriteProcessMemory|Lib|` +
+	`PowerShell|cmd\.exe|StrReverse|FromBase64String|Scripting\.FileSystemObject)\b`)
+
+// Analyze returns the auto-exec entry points and suspicious keywords found in code, each
+// once and sorted
+func Analyze(code string) (autoExec []string, suspicious []string) {
+	return uniqueMatches(autoExecRegexp, code), uniqueMatches(suspiciousRegexp, code)
+}
+
+func uniqueMatches(re *regexp.Regexp, s string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, m := range re.FindAllString(s, -1) {
+		key := strings.ToLower(m)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, m)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Decompress expands data compressed with the algorithm in MS-OVBA section 2.4.1
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 1 {
+		return nil, ErrCompressed
+	}
+
+	var result []byte
+	for pos := 1; pos < len(data); {
+		header, ok := binary.Uint16Le(data, pos)
+		if !ok {
+			// A trailing byte cannot hold a chunk
+			break
+		}
+
+		chunkEnd := pos + int(header&0x0FFF) + 3
+		if chunkEnd > len(data) {
+			chunkEnd = len(data)
+		}
+		pos += 2
+
+		if header&0x8000 == 0 {
+			// Uncompressed chunks always hold 4096 bytes
+			end := pos + 4096
+			if end > len(data) {
+				end = len(data)
+			}
+			result = append(result, data[pos:end]...)
+			pos = end
+			continue
+		}
+
+		chunkStart := len(result)
+		for pos < chunkEnd {
+			flags := data[pos]
+			pos++
+
+			for bit := uint(0); bit < 8 && pos < chunkEnd; bit++ {
+				if flags&(1<<bit) == 0 {
+					result = append(result, data[pos])
+					pos++
+					continue
+				}
+
+				token, ok := binary.Uint16Le(data, pos)
+				if !ok {
+					return result, ErrCompressed
+				}
+				pos += 2
+
+				// The split between offset and length depends on how much of the chunk has
+				// been decompressed
+				bitCount := uint(4)
+				for difference := len(result) - chunkStart; (1 << bitCount) < difference; {
+					bitCount++
+				}
+				lengthMask := uint16(0xFFFF) >> bitCount
+				length := int(token&lengthMask) + 3
+				offset := int(token>>(16-bitCount)) + 1
+
+				source := len(result) - offset
+				if source < chunkStart {
+					return result, ErrCompressed
+				}
+				if len(result)+length > MaxDecompressedSize {
+					return result, ErrCompressed
+				}
+				// Copies may overlap their own output, so go byte by byte
+				for i := 0; i < length; i++ {
+					result = append(result, result[source+i])
+				}
+			}
+		}
+		pos = chunkEnd
+	}
+
+	return result, nil
+}
+
+type moduleRecord struct {
+	name   string
+	stream string
+	offset uint32
+}
+
+// Parses the decompressed dir stream for the name, stream and source offset of each module
+func parseDir(dir []byte) []moduleRecord {
+	var modules []moduleRecord
+	current := moduleRecord{}
+	inModule := false
+
+	for pos := 0; pos+6 <= len(dir); {
+		id, _ := binary.Uint16Le(dir, pos)
+		size, _ := binary.Uint32Le(dir, pos+2)
+		pos += 6
+
+		if id == recordProjectVersion {
+			// The size field is a reserved value, the record holds 6 bytes
+			size = 6
+		}
+
+		if uint64(pos)+uint64(size) > uint64(len(dir)) {
+			break
+		}
+		value := dir[pos : pos+int(size)]
+		pos += int(size)
+
+		switch id {
+		case recordModuleName:
+			current = moduleRecord{name: string(value)}
+			inModule = true
+		case recordModuleStreamName:
+			current.stream = string(value)
+		case recordModuleOffset:
+			current.offset, _ = binary.Uint32Le(value, 0)
+		case recordModuleTerminator:
+			if inModule {
+				if current.stream == "" {
+					current.stream = current.name
+				}
+				modules = append(modules, current)
+			}
+			inModule = false
+		}
+	}
+
+	return modules
+}
+
+// Projects returns the VBA projects in a compound file, which hold one project per
+// storage containing a VBA storage with a dir stream
+func Projects(f *ole.File) ([]Project, error) {
+	var projects []Project
+	for _, e := range f.Streams() {
+		if !strings.EqualFold(e.Name, "dir") || !strings.EqualFold(path.Base(path.Dir(e.Path)), "VBA") {
+			continue
+		}
+
+		vbaPath := path.Dir(e.Path)
+		compressed, err := f.ReadEntry(e)
+		if err != nil {
+			return projects, err
+		}
+		dir, err := Decompress(compressed)
+		if err != nil {
+			return projects, err
+		}
+
+		project := Project{Path: vbaPath}
+		for _, m := range parseDir(dir) {
+			module := Module{Name: m.name, Stream: vbaPath + "/" + m.stream}
+
+			data, err := f.ReadStream(module.Stream)
+			if err == nil && int64(m.offset) > int64(len(data)) {
+				err = ErrCompressed
+			}
+			if err == nil {
+				var code []byte
+				code, err = Decompress(data[m.offset:])
+				module.Code = string(code)
+				module.AutoExec, module.Suspicious = Analyze(module.Code)
+			}
+			module.Err = err
+
+			project.Modules = append(project.Modules, module)
+		}
+
+		projects = append(projects, project)
+	}
+
+	if len(projects) == 0 {
+		return nil, ErrNoProject
+	}
+
+	return projects, nil
+}
@@ -0,0 +1,84 @@
+package vba_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gdcorp-infosec/threat-util/help/ole"
+	"github.com/gdcorp-infosec/threat-util/help/vba"
+)
+
+func TestDecompress(t *testing.T) {
+	// Example from MS-OVBA section 3.2.3, which uses both literals and copy tokens
+	compressed, _ := hex.DecodeString("012fb000236161616263646582660070616768696a013808616b6c00306d6e6f700671027004107273747576107778797a003c")
+	expected := "#aaabcdefaaaaghijaaaaaklaaamnopqaaaaaaaaaaaarstuvwxyzaaa"
+
+	result, err := vba.Decompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != expected {
+		t.Fatalf("bad decompression %q", result)
+	}
+
+	if _, err := vba.Decompress([]byte{0, 1, 2}); err != vba.ErrCompressed {
+		t.Fatalf("bad signature error %v", err)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vbaProject.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ole.Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := vba.Projects(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(projects) != 1 || projects[0].Path != "VBA" || len(projects[0].Modules) != 2 {
+		t.Fatalf("bad projects %+v", projects)
+	}
+
+	thisDocument := projects[0].Modules[0]
+	if thisDocument.Name != "ThisDocument" || thisDocument.Stream != "VBA/ThisDocument" || thisDocument.Err != nil {
+		t.Fatalf("bad module %+v", thisDocument)
+	}
+	if !strings.Contains(thisDocument.Code, `Set s = CreateObject("WScript.Shell")`) {
+		t.Fatalf("bad code %q", thisDocument.Code)
+	}
+	if !reflect.DeepEqual(thisDocument.AutoExec, []string{"Document_Open"}) {
+		t.Fatalf("bad auto-exec %v", thisDocument.AutoExec)
+	}
+	if !reflect.DeepEqual(thisDocument.Suspicious, []string{"CreateObject", "WScript.Shell"}) {
+		t.Fatalf("bad suspicious keywords %v", thisDocument.Suspicious)
+	}
+
+	module1 := projects[0].Modules[1]
+	if module1.Name != "Module1" || !reflect.DeepEqual(module1.AutoExec, []string{"AutoOpen"}) ||
+		!reflect.DeepEqual(module1.Suspicious, []string{"Shell", "cmd.exe"}) {
+		t.Fatalf("bad module %+v", module1)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	autoExec, suspicious := vba.Analyze("Sub Workbook_Open()\r\n  Dim x: x = 1\r\nEnd Sub\r\nSub Helper()\r\nEnd Sub\r\n")
+	if !reflect.DeepEqual(autoExec, []string{"Workbook_Open"}) || suspicious != nil {
+		t.Fatalf("bad analysis %v %v", autoExec, suspicious)
+	}
+
+	// Words containing keywords are not matches
+	autoExec, suspicious = vba.Analyze("Dim Shellfish, NoAutoOpen")
+	if autoExec != nil || suspicious != nil {
+		t.Fatalf("bad analysis %v %v", autoExec, suspicious)
+	}
+}